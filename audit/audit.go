@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/k8s"
+	"github.com/luizalabs/sindico/storage"
+)
+
+type Config struct {
+	Dir string `split_words:"true" default:"audit"`
+}
+
+// Record is a single change made to a cluster object by sindico.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Cluster   string    `json:"cluster,omitempty"`
+	Actor     string    `json:"actor"`
+	User      string    `json:"user,omitempty"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Field     string    `json:"field,omitempty"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to,omitempty"`
+}
+
+func (r Record) String() string {
+	actor := r.Actor
+	if r.User != "" {
+		actor = fmt.Sprintf("%s (%s)", actor, r.User)
+	}
+	change := k8s.Change{Kind: r.Kind, Namespace: r.Namespace, Name: r.Name, Field: r.Field, From: r.From, To: r.To}
+	if r.Cluster != "" {
+		return fmt.Sprintf("%s [%s] %s: %s", r.Time.Format(time.RFC3339), r.Cluster, actor, change)
+	}
+	return fmt.Sprintf("%s %s: %s", r.Time.Format(time.RFC3339), actor, change)
+}
+
+// Filter selects records, empty fields match anything.
+type Filter struct {
+	Cluster   string
+	Namespace string
+	Name      string
+	Kind      string
+}
+
+func (f *Filter) match(r *Record) bool {
+	return (f.Cluster == "" || f.Cluster == r.Cluster) &&
+		(f.Namespace == "" || f.Namespace == r.Namespace) &&
+		(f.Name == "" || f.Name == r.Name) &&
+		(f.Kind == "" || f.Kind == r.Kind)
+}
+
+// Log keeps the records as JSON lines in a storage.Daily, e.g. under
+// audit/2018-08-01/.
+type Log struct {
+	daily  *storage.Daily
+	logger log.Logger
+}
+
+func New(st storage.ReadWriter, cfg *Config) *Log {
+	return &Log{daily: storage.NewDaily(st, cfg.Dir), logger: log.New("component", "audit")}
+}
+
+// Recorder returns a function recording the changes made by actor, the
+// controller, on cluster. It's meant to be given to k8s.Client.WithAudit.
+func (l *Log) Recorder(cluster, actor string) func(user string, changes []k8s.Change) {
+	return func(user string, changes []k8s.Change) {
+		now := time.Now()
+		records := make([]Record, len(changes))
+		for i, c := range changes {
+			records[i] = Record{
+				Time:      now,
+				Cluster:   cluster,
+				Actor:     actor,
+				User:      user,
+				Kind:      c.Kind,
+				Namespace: c.Namespace,
+				Name:      c.Name,
+				Field:     c.Field,
+				From:      c.From,
+				To:        c.To,
+			}
+			l.logger.Info("change made", "cluster", cluster, "actor", actor, "user", user, "change", c.String())
+		}
+		if err := l.Append(records...); err != nil {
+			l.logger.Error("failed to store audit records", "err", err)
+		}
+	}
+}
+
+// Append adds records to the file of the day of the first one.
+func (l *Log) Append(records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	rs := make([]interface{}, len(records))
+	for i := range records {
+		rs[i] = records[i]
+	}
+	return l.daily.Append(records[0].Time, rs...)
+}
+
+// Query returns the records of the last days matching f, oldest first.
+func (l *Log) Query(days int, f *Filter) ([]Record, error) {
+	records := []Record{}
+	err := l.daily.Scan(days, func(line []byte) error {
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		if f.match(&r) {
+			records = append(records, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
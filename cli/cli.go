@@ -0,0 +1,334 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/audit"
+	"github.com/luizalabs/sindico/config"
+	"github.com/luizalabs/sindico/controllers"
+	"github.com/luizalabs/sindico/history"
+	"github.com/luizalabs/sindico/k8s"
+	"github.com/luizalabs/sindico/logging"
+	"github.com/luizalabs/sindico/manager"
+	"github.com/luizalabs/sindico/notification"
+	"github.com/luizalabs/sindico/storage"
+	"github.com/pkg/errors"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const syncTimeout = time.Minute
+
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(fs *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{"run", "", "run every enabled controller until stopped (default)", runManager},
+	{"backup etcd", "[--cluster name]", "make an etcd backup right away", backupEtcd},
+	{"check pods", "[--cluster name]", "print the crashed and not ready pods report", checkPods},
+	{"digest", "[--cluster name]", "build the cluster health digest and print its messages", digest},
+	{"watchdog", "[--report|--apply] [--cluster name]", "list the watchdog violations, --apply fixes them after a confirmation", watchdog},
+	{"audit", "[--days n] [--namespace ns] [flags]", "list the changes made by sindico", queryAudit},
+	{"config validate", "[file]", "validate the config file and env vars", validateConfig},
+}
+
+// Run executes the subcommand given by args and returns the exit code.
+// Without arguments the controllers are run, as before subcommands existed.
+func Run(args []string) int {
+	if len(args) == 0 {
+		args = []string{"run"}
+	}
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) < len(words) || strings.Join(args[:len(words)], " ") != cmd.name {
+			continue
+		}
+		fs := flag.NewFlagSet("sindico "+cmd.name, flag.ContinueOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "usage: sindico %s %s\n", cmd.name, cmd.args)
+			fs.PrintDefaults()
+		}
+		if err := cmd.run(fs, args[len(words):]); err != nil {
+			if err != flag.ErrHelp {
+				fmt.Fprintln(os.Stderr, "error:", err)
+			}
+			return 1
+		}
+		return 0
+	}
+	usage(os.Stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: sindico <command> [flags]\n\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-40s %s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.short)
+	}
+}
+
+func runManager(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	manager.Run()
+	return nil
+}
+
+func backupEtcd(fs *flag.FlagSet, args []string) error {
+	cluster := fs.String("cluster", "", "only backup this cluster")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return runOnce([]string{"etcdbackup"}, *cluster, false)
+}
+
+func digest(fs *flag.FlagSet, args []string) error {
+	cluster := fs.String("cluster", "", "only digest this cluster")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return runOnce([]string{"digest"}, *cluster, false)
+}
+
+func checkPods(fs *flag.FlagSet, args []string) error {
+	cluster := fs.String("cluster", "", "only check this cluster")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return runOnce([]string{"kubewatch"}, *cluster, true)
+}
+
+func watchdog(fs *flag.FlagSet, args []string) error {
+	cluster := fs.String("cluster", "", "only check this cluster")
+	report := fs.Bool("report", false, "only list the violations, the default")
+	apply := fs.Bool("apply", false, "fix the violations, after a confirmation, instead of listing them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *report && *apply {
+		return errors.New("use either --report or --apply")
+	}
+	names := []string{}
+	for _, name := range controllers.Registered() {
+		if controllers.Parent(name) == "watchdog" {
+			names = append(names, name)
+		}
+	}
+	if *apply {
+		clusters := "every cluster"
+		if *cluster != "" {
+			clusters = "cluster " + *cluster
+		}
+		question := fmt.Sprintf("fix the watchdog violations of %s as %s?", clusters, os.Getenv("USER"))
+		if !confirm(os.Stdin, os.Stderr, question) {
+			return errors.New("aborted")
+		}
+	}
+	return runOnce(names, *cluster, !*apply)
+}
+
+// confirm asks question on w and tells if the answer read from r is yes.
+func confirm(r io.Reader, w io.Writer, question string) bool {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func validateConfig(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	if path == "" {
+		path = os.Getenv("SINDICO_CONFIG_FILE")
+	}
+	var err error
+	if path == "" {
+		err = config.Validate()
+	} else {
+		_, err = config.Load(path)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("config is valid")
+	return nil
+}
+
+// runOnce does a single run of the controllers on every cluster, or only
+// on cluster if given. Messages are printed to stdout instead of being
+// sent and, in report mode, writes and events are printed instead of made
+// and nothing is added to the history.
+func runOnce(names []string, cluster string, report bool) error {
+	if err := setup(); err != nil {
+		return err
+	}
+	clients, err := newK8sClusters(cluster)
+	if err != nil {
+		return err
+	}
+	st, aud, err := newAudit()
+	if err != nil {
+		return err
+	}
+	var hist *history.Log
+	if !report {
+		if hist, err = newHistory(st); err != nil {
+			return err
+		}
+	}
+	nt := notification.NewWriter(os.Stdout)
+	var errs []error
+	for _, k := range clients {
+		cnt := nt.WithCluster(k.Cluster()).WithNamespaces(k)
+		view := func(k *k8s.Client, name string) *k8s.Client {
+			if report {
+				return k.WithDryRun(log.New("dry_run", true), func(msg string, _ k8s.Change) {
+					cnt.PostMessage(msg, "")
+				})
+			}
+			// writes are made on behalf of whoever runs the command
+			record := aud.Recorder(k.Cluster(), name)
+			return k.WithAudit(func(_ string, changes []k8s.Change) {
+				record(os.Getenv("USER"), changes)
+			})
+		}
+		deps := &controllers.Deps{Storage: st, Notification: cnt, Audit: aud, History: hist}
+		if err := runOnceOn(k, names, view, deps); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func runOnceOn(k *k8s.Client, names []string, view func(*k8s.Client, string) *k8s.Client, deps *controllers.Deps) error {
+	ctrls := make([]controllers.OneShot, 0, len(names))
+	for _, name := range names {
+		d := *deps
+		d.K8s = view(k, name)
+		d.Notification = deps.Notification.WithController(name)
+		ctrl, err := controllers.New(name, &d)
+		if err != nil {
+			return errors.Wrapf(err, "failed to build %s ctrl", name)
+		}
+		o, ok := ctrl.(controllers.OneShot)
+		if !ok {
+			return fmt.Errorf("%s can't run once", name)
+		}
+		ctrls = append(ctrls, o)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+	if err := k.StartInformers(ctx.Done()); err != nil {
+		return errors.Wrapf(err, "cluster %s unreachable", k.Cluster())
+	}
+	var errs []error
+	for i, o := range ctrls {
+		if err := o.RunOnce(); err != nil {
+			errs = append(errs, errors.Wrap(err, names[i]))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func queryAudit(fs *flag.FlagSet, args []string) error {
+	days := fs.Int("days", 7, "number of days to look back")
+	asJSON := fs.Bool("json", false, "print the records as json lines")
+	var f audit.Filter
+	fs.StringVar(&f.Cluster, "cluster", "", "only changes of this cluster")
+	fs.StringVar(&f.Namespace, "namespace", "", "only changes of this namespace")
+	fs.StringVar(&f.Name, "name", "", "only changes of objects with this name")
+	fs.StringVar(&f.Kind, "kind", "", "only changes of this kind, e.g. hpa")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := setup(); err != nil {
+		return err
+	}
+	_, aud, err := newAudit()
+	if err != nil {
+		return err
+	}
+	records, err := aud.Query(*days, &f)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, r := range records {
+		if *asJSON {
+			enc.Encode(r)
+		} else {
+			fmt.Println(r)
+		}
+	}
+	return nil
+}
+
+// setup configures the logger and loads the config file. stdout is left
+// for the output of the command, the log config is read again once the
+// config file is loaded.
+func setup() error {
+	if err := logging.Setup(os.Stderr); err != nil {
+		return err
+	}
+	if _, err := manager.LoadConfigFile(); err != nil {
+		return err
+	}
+	return logging.Setup(os.Stderr)
+}
+
+func newAudit() (*storage.Client, *audit.Log, error) {
+	var stCfg storage.Config
+	if err := config.Process("sindico_storage", &stCfg); err != nil {
+		return nil, nil, err
+	}
+	var cfg audit.Config
+	if err := config.Process("sindico_audit", &cfg); err != nil {
+		return nil, nil, err
+	}
+	st := storage.New(&stCfg)
+	return st, audit.New(st, &cfg), nil
+}
+
+func newHistory(st *storage.Client) (*history.Log, error) {
+	var cfg history.Config
+	if err := config.Process("sindico_history", &cfg); err != nil {
+		return nil, err
+	}
+	return history.New(st, &cfg), nil
+}
+
+func newK8sClusters(name string) ([]*k8s.Client, error) {
+	var cfg k8s.Config
+	if err := config.Process("sindico_k8s", &cfg); err != nil {
+		return nil, err
+	}
+	clients, err := k8s.NewClusters(&cfg)
+	if err != nil || name == "" {
+		return clients, err
+	}
+	for _, k := range clients {
+		if k.Cluster() == name {
+			return []*k8s.Client{k}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cluster %s", name)
+}
@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   bool
+	}{
+		{"y\n", true},
+		{"Yes\n", true},
+		{" y ", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+		{"yep\n", false},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if got := confirm(strings.NewReader(tt.answer), &out, "apply?"); got != tt.want {
+			t.Errorf("answer %q: got %v, want %v", tt.answer, got, tt.want)
+		}
+		if out.String() != "apply? [y/N] " {
+			t.Errorf("got prompt %q", out.String())
+		}
+	}
+}
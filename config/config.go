@@ -0,0 +1,347 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	log "github.com/inconshreveable/log15"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+)
+
+const rootPrefix = "SINDICO"
+
+var (
+	wordsRe = regexp.MustCompile("([^A-Z]+|[A-Z][^A-Z]+|[A-Z]+)")
+
+	mu      sync.RWMutex
+	current = &File{values: map[string]string{}}
+	specs   = make(map[string]reflect.Type)
+)
+
+// File holds the values of a yaml config file flattened to the env var
+// names used by envconfig, so `kube_watch: {circle_time: 5}` (or
+// `kubeWatch: {circleTime: 5}`) becomes SINDICO_KUBE_WATCH_CIRCLE_TIME=5.
+type File struct {
+	path   string
+	sum    [sha1.Size]byte
+	values map[string]string
+}
+
+// Validator is implemented by config structs with rules beyond type parsing.
+type Validator interface {
+	Validate() error
+}
+
+// Register makes the spec known to Validate. It's usually called from the
+// init function of the package owning the config struct.
+func Register(prefix string, spec interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	specs[strings.ToUpper(prefix)] = reflect.TypeOf(spec).Elem()
+}
+
+// Process is a drop-in replacement of envconfig.Process. Values are taken
+// from env vars, then from the current config file, then from the default
+// tag.
+func Process(prefix string, spec interface{}) error {
+	mu.RLock()
+	f := current
+	mu.RUnlock()
+	return f.Process(prefix, spec)
+}
+
+func (f *File) Process(prefix string, spec interface{}) error {
+	if err := envconfig.Process(prefix, spec); err != nil {
+		return err
+	}
+	s := reflect.ValueOf(spec).Elem()
+	for i := 0; i < s.NumField(); i++ {
+		key, ok := fieldKey(prefix, s.Type().Field(i))
+		if !ok {
+			continue
+		}
+		if _, found := os.LookupEnv(key); found {
+			continue
+		}
+		value, found := f.values[key]
+		if !found {
+			continue
+		}
+		if err := setField(s.Field(i), value); err != nil {
+			return errors.Wrapf(err, "invalid value %q for %s", value, f.keyName(key))
+		}
+	}
+	return nil
+}
+
+// Validate processes every registered spec against f, rejecting unknown keys,
+// values that can't be parsed and specs failing their own validation.
+func (f *File) Validate() error {
+	mu.RLock()
+	defer mu.RUnlock()
+	known := make(map[string]bool)
+	prefixes := make([]string, 0, len(specs))
+	for prefix := range specs {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		typ := specs[prefix]
+		for i := 0; i < typ.NumField(); i++ {
+			if key, ok := fieldKey(prefix, typ.Field(i)); ok {
+				known[key] = true
+			}
+		}
+		spec := reflect.New(typ).Interface()
+		if err := f.Process(prefix, spec); err != nil {
+			return err
+		}
+		if v, ok := spec.(Validator); ok {
+			if err := v.Validate(); err != nil {
+				return errors.Wrapf(err, "invalid %s config", strings.ToLower(prefix))
+			}
+		}
+	}
+	for key := range f.values {
+		if !known[key] {
+			return fmt.Errorf("unknown config key %s", f.keyName(key))
+		}
+	}
+	return nil
+}
+
+// Validate validates the env vars and the current config file.
+func Validate() error {
+	mu.RLock()
+	f := current
+	mu.RUnlock()
+	return f.Validate()
+}
+
+func (f *File) keyName(key string) string {
+	if f.path == "" {
+		return key
+	}
+	return fmt.Sprintf("%s (%s)", strings.ToLower(strings.TrimPrefix(key, rootPrefix+"_")), f.path)
+}
+
+// Load reads and validates the config file at path.
+func Load(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config file")
+	}
+	f, err := parse(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Set makes f the config file used by Process.
+func Set(f *File) {
+	mu.Lock()
+	current = f
+	mu.Unlock()
+}
+
+// Watch polls the config file and replaces the current one when it changes,
+// then calls onReload, if given, for the settings not read on every use.
+// Invalid files are logged and ignored, keeping the last valid config.
+func Watch(path string, interval time.Duration, stopCh <-chan struct{}, onReload func()) {
+	logger := log.New("component", "config")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			logger.Error("failed to read config file", "path", path, "err", err)
+			continue
+		}
+		mu.RLock()
+		unchanged := current.sum == sha1.Sum(data)
+		mu.RUnlock()
+		if unchanged {
+			continue
+		}
+		f, err := parse(path, data)
+		if err == nil {
+			err = f.Validate()
+		}
+		if err != nil {
+			logger.Error("invalid config rejected", "path", path, "err", err)
+			continue
+		}
+		Set(f)
+		logger.Info("config reloaded", "path", path)
+		if onReload != nil {
+			onReload()
+		}
+	}
+}
+
+func parse(path string, data []byte) (*File, error) {
+	f := &File{path: path, sum: sha1.Sum(data), values: make(map[string]string)}
+	raw, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse config file %s", path)
+	}
+	var tree interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse config file %s", path)
+	}
+	if tree == nil {
+		return f, nil
+	}
+	root, ok := tree.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config file %s must be a yaml map", path)
+	}
+	if err := flatten(rootPrefix, root, f.values); err != nil {
+		return nil, errors.Wrapf(err, "invalid config file %s", path)
+	}
+	return f, nil
+}
+
+func flatten(prefix string, tree map[string]interface{}, values map[string]string) error {
+	for k, v := range tree {
+		key := prefix + "_" + toKey(k)
+		switch val := v.(type) {
+		case nil:
+		case map[string]interface{}:
+			if err := flatten(key, val, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			items := make([]string, len(val))
+			for i, item := range val {
+				s, err := scalar(item)
+				if err != nil {
+					return errors.Wrapf(err, "invalid item of %s", strings.ToLower(k))
+				}
+				items[i] = s
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			s, err := scalar(val)
+			if err != nil {
+				return errors.Wrapf(err, "invalid value of %s", strings.ToLower(k))
+			}
+			values[key] = s
+		}
+	}
+	return nil
+}
+
+func scalar(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case json.Number:
+		return val.String(), nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	}
+	return "", fmt.Errorf("unexpected %T", v)
+}
+
+func toKey(name string) string {
+	words := wordsRe.FindAllString(name, -1)
+	for i, w := range words {
+		words[i] = strings.Trim(w, "_-")
+	}
+	return strings.ToUpper(strings.Join(words, "_"))
+}
+
+// fieldKey mirrors the env var naming of envconfig.
+func fieldKey(prefix string, field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	if ignored, _ := strconv.ParseBool(field.Tag.Get("ignored")); ignored {
+		return "", false
+	}
+	key := field.Name
+	if split, _ := strconv.ParseBool(field.Tag.Get("split_words")); split {
+		key = strings.Join(wordsRe.FindAllString(field.Name, -1), "_")
+	}
+	if alt := field.Tag.Get("envconfig"); alt != "" {
+		key = alt
+	}
+	return strings.ToUpper(prefix + "_" + key), true
+}
+
+func setField(field reflect.Value, value string) error {
+	typ := field.Type()
+	switch typ.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if typ == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			field.SetInt(int64(d))
+			return nil
+		}
+		val, err := strconv.ParseInt(value, 0, typ.Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(val)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val, err := strconv.ParseUint(value, 0, typ.Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(val)
+	case reflect.Bool:
+		val, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(val)
+	case reflect.Float32, reflect.Float64:
+		val, err := strconv.ParseFloat(value, typ.Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(val)
+	case reflect.Slice:
+		vals := strings.Split(value, ",")
+		sl := reflect.MakeSlice(typ, len(vals), len(vals))
+		for i, val := range vals {
+			if err := setField(sl.Index(i), strings.TrimSpace(val)); err != nil {
+				return err
+			}
+		}
+		field.Set(sl)
+	default:
+		return fmt.Errorf("unsupported type %s", typ)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Name     string        `split_words:"true" default:"sindico"`
+	MaxItems int           `split_words:"true" default:"1"`
+	Interval time.Duration `split_words:"true" default:"1m"`
+	Channels []string      `split_words:"true"`
+	DryRun   bool          `envconfig:"dry_run"`
+}
+
+func (c *testConfig) Validate() error {
+	if c.MaxItems < 1 {
+		return fmt.Errorf("max items must be at least 1, got %d", c.MaxItems)
+	}
+	return nil
+}
+
+func init() {
+	Register("sindico_test", &testConfig{})
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "empty", yaml: "", want: map[string]string{}},
+		{
+			name: "snake case",
+			yaml: "test:\n  max_items: 5\n  dry_run: true\n",
+			want: map[string]string{"SINDICO_TEST_MAX_ITEMS": "5", "SINDICO_TEST_DRY_RUN": "true"},
+		},
+		{
+			name: "camel case",
+			yaml: "test:\n  maxItems: 5\n  interval: 30s\n",
+			want: map[string]string{"SINDICO_TEST_MAX_ITEMS": "5", "SINDICO_TEST_INTERVAL": "30s"},
+		},
+		{
+			name: "nested maps",
+			yaml: "kube_watch:\n  notification:\n    channel: '#alerts'\n",
+			want: map[string]string{"SINDICO_KUBE_WATCH_NOTIFICATION_CHANNEL": "#alerts"},
+		},
+		{
+			name: "lists",
+			yaml: "test:\n  channels: ['#a', '#b']\n",
+			want: map[string]string{"SINDICO_TEST_CHANNELS": "#a,#b"},
+		},
+		{
+			name: "null values",
+			yaml: "test:\n  name: null\n",
+			want: map[string]string{},
+		},
+		{name: "not a map", yaml: "- a\n", wantErr: "must be a yaml map"},
+		{name: "map in a list", yaml: "test:\n  channels: [{a: b}]\n", wantErr: "invalid item of channels"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parse("sindico.yaml", []byte(tt.yaml))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(f.values, tt.want) {
+				t.Errorf("got %v, want %v", f.values, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		env  map[string]string
+		want testConfig
+	}{
+		{
+			name: "defaults",
+			want: testConfig{Name: "sindico", MaxItems: 1, Interval: time.Minute},
+		},
+		{
+			name: "file over defaults",
+			yaml: "test:\n  name: file\n  max_items: 5\n  channels: ['#a', '#b']\n  dry_run: true\n",
+			want: testConfig{Name: "file", MaxItems: 5, Interval: time.Minute, Channels: []string{"#a", "#b"}, DryRun: true},
+		},
+		{
+			name: "env over file",
+			yaml: "test:\n  name: file\n  max_items: 5\n",
+			env:  map[string]string{"SINDICO_TEST_NAME": "env", "SINDICO_TEST_INTERVAL": "5s"},
+			want: testConfig{Name: "env", MaxItems: 5, Interval: 5 * time.Second},
+		},
+		{
+			name: "empty env over file",
+			yaml: "test:\n  name: file\n",
+			env:  map[string]string{"SINDICO_TEST_NAME": ""},
+			want: testConfig{MaxItems: 1, Interval: time.Minute},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+			f, err := parse("sindico.yaml", []byte(tt.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got testConfig
+			if err := f.Process("sindico_test", &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{name: "valid", yaml: "test:\n  max_items: 2\n"},
+		{name: "unknown key", yaml: "test:\n  max_itmes: 2\n", wantErr: "unknown config key test_max_itmes (sindico.yaml)"},
+		{name: "invalid value", yaml: "test:\n  max_items: many\n", wantErr: "invalid value \"many\" for test_max_items (sindico.yaml)"},
+		{name: "failed validation", yaml: "test:\n  max_items: 0\n", wantErr: "invalid sindico_test config: max items must be at least 1, got 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parse("sindico.yaml", []byte(tt.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sindico-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sindico.yaml")
+	write := func(yaml string) {
+		if err := ioutil.WriteFile(path, []byte(yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	maxItems := func() int {
+		var cfg testConfig
+		if err := Process("sindico_test", &cfg); err != nil {
+			t.Fatal(err)
+		}
+		return cfg.MaxItems
+	}
+
+	write("test:\n  max_items: 2\n")
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Set(f)
+	defer Set(&File{values: map[string]string{}})
+
+	reloaded := make(chan struct{}, 10)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go Watch(path, 10*time.Millisecond, stopCh, func() { reloaded <- struct{}{} })
+
+	steps := []struct {
+		name   string
+		yaml   string
+		reload bool
+		want   int
+	}{
+		{name: "changed", yaml: "test:\n  max_items: 3\n", reload: true, want: 3},
+		{name: "invalid value kept out", yaml: "test:\n  max_items: 0\n", want: 3},
+		{name: "unknown key kept out", yaml: "test:\n  max_itmes: 4\n", want: 3},
+		{name: "broken yaml kept out", yaml: "test: [\n", want: 3},
+		{name: "changed again", yaml: "test:\n  max_items: 5\n", reload: true, want: 5},
+	}
+	for _, step := range steps {
+		write(step.yaml)
+		select {
+		case <-reloaded:
+			if !step.reload {
+				t.Fatalf("%s: got reloaded, want the last valid config kept", step.name)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if step.reload {
+				t.Fatalf("%s: not reloaded", step.name)
+			}
+		}
+		if got := maxItems(); got != step.want {
+			t.Errorf("%s: got max items %d, want %d", step.name, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/audit"
+	"github.com/luizalabs/sindico/history"
+	"github.com/luizalabs/sindico/k8s"
+	"github.com/luizalabs/sindico/notification"
+	"github.com/luizalabs/sindico/storage"
+)
+
+var (
+	mu        sync.Mutex
+	factories = make(map[string]Factory)
+	primary   = make(map[string]bool)
+)
+
+type Controller interface {
+	Run(stopCh <-chan struct{})
+}
+
+// Deps are the clients shared by every controller of a cluster, built once
+// by the manager.
+type Deps struct {
+	K8s          *k8s.Client
+	Storage      *storage.Client
+	Notification *notification.Client
+	Audit        *audit.Log
+	History      *history.Log
+}
+
+// OneShot is implemented by periodic controllers that can do a single run
+// on demand, e.g. from the command line.
+type OneShot interface {
+	RunOnce() error
+}
+
+type Factory func(deps *Deps) (Controller, error)
+
+// Register makes a controller available to the manager. Subcontrollers are
+// named after their parent, e.g. watchdog/hpa, so they can be selected as a
+// group. It's meant to be called from init and panics on duplicated names.
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, found := factories[name]; found {
+		panic(fmt.Sprintf("controller %s registered twice", name))
+	}
+	factories[name] = f
+}
+
+// RegisterPrimary is like Register for controllers that must run only once,
+// against the first configured cluster.
+func RegisterPrimary(name string, f Factory) {
+	Register(name, f)
+	mu.Lock()
+	primary[name] = true
+	mu.Unlock()
+}
+
+// PrimaryOnly tells if the controller was registered with RegisterPrimary.
+func PrimaryOnly(name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return primary[name]
+}
+
+// Registered returns the sorted names of the registered controllers.
+func Registered() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Names returns the registered names plus the names of their parents.
+func Names() []string {
+	set := make(map[string]bool)
+	for _, name := range Registered() {
+		set[name] = true
+		set[Parent(name)] = true
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func Parent(name string) string {
+	return strings.SplitN(name, "/", 2)[0]
+}
+
+// Logger returns a logger with ctx and the name of the cluster, if any.
+func Logger(cluster string, ctx ...interface{}) log.Logger {
+	if cluster != "" {
+		ctx = append(ctx, "cluster", cluster)
+	}
+	return log.New(ctx...)
+}
+
+func New(name string, deps *Deps) (Controller, error) {
+	mu.Lock()
+	f, found := factories[name]
+	mu.Unlock()
+	if !found {
+		return nil, fmt.Errorf("unknown controller %s", name)
+	}
+	return f(deps)
+}
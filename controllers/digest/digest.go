@@ -0,0 +1,251 @@
+package digest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/audit"
+	"github.com/luizalabs/sindico/config"
+	"github.com/luizalabs/sindico/controllers"
+	"github.com/luizalabs/sindico/history"
+	"github.com/luizalabs/sindico/notification"
+	"github.com/luizalabs/sindico/scheduler"
+	"github.com/luizalabs/sindico/status"
+	"github.com/pkg/errors"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+type K8s interface {
+	Cluster() string
+	GetLabelValue(namespace, label string) (string, error)
+}
+
+type Storage interface {
+	UploadFile(path string, r io.ReadSeeker) error
+}
+
+type Notification interface {
+	Send(m *notification.Message, channel string) error
+	Routed(meta notification.Meta, channel string) bool
+	Alerts(f notification.Meta) []notification.Alert
+}
+
+type History interface {
+	Query(days int, f *history.Filter) ([]history.Event, error)
+}
+
+type Audit interface {
+	Query(days int, f *audit.Filter) ([]audit.Record, error)
+}
+
+type DigestConfig struct {
+	Schedule            string `split_words:"true" default:"0 9 * * 1"`
+	Days                int    `split_words:"true" default:"7"`
+	Dir                 string `split_words:"true" default:"digest"`
+	NotificationChannel string `split_words:"true" default:"#alerts"`
+	TeamNsLabel         string `split_words:"true" default:"teresa.io/team"`
+}
+
+func (c *DigestConfig) Validate() error {
+	if c.Days < 1 {
+		return fmt.Errorf("days must be at least 1, got %d", c.Days)
+	}
+	_, _, err := scheduler.Parse(c.Schedule)
+	return err
+}
+
+type Controller struct {
+	k8s    K8s
+	st     Storage
+	nt     Notification
+	hist   History
+	aud    Audit
+	logger log.Logger
+	status *status.Component
+}
+
+func NewController(k8s K8s, st Storage, nt Notification, hist History, aud Audit) *Controller {
+	return &Controller{
+		k8s:    k8s,
+		st:     st,
+		nt:     nt,
+		hist:   hist,
+		aud:    aud,
+		logger: controllers.Logger(k8s.Cluster(), "controller", "digest"),
+		status: status.For(k8s.Cluster(), "digest"),
+	}
+}
+
+func init() {
+	config.Register("sindico_digest", &DigestConfig{})
+	controllers.Register("digest", func(deps *controllers.Deps) (controllers.Controller, error) {
+		return NewController(deps.K8s, deps.Storage, deps.Notification, deps.History, deps.Audit), nil
+	})
+}
+
+func (c *Controller) Run(stopCh <-chan struct{}) {
+	var cfg DigestConfig
+	if err := config.Process("sindico_digest", &cfg); err != nil {
+		c.logger.Error("failed to process config", "err", err)
+		c.status.Fail(err)
+		return
+	}
+	c.logger.Debug("starting")
+	fn := func() {
+		var newCfg DigestConfig
+		if err := config.Process("sindico_digest", &newCfg); err != nil {
+			c.logger.Error("failed to reload config", "err", err)
+		} else {
+			cfg = newCfg
+		}
+		c.status.Run(func() error { return c.digest(&cfg) })
+	}
+	spec := func() string { return cfg.Schedule }
+	if err := scheduler.Run(c.status, spec, fn, stopCh); err != nil {
+		c.logger.Error("invalid schedule", "err", err)
+		c.status.Fail(err)
+		return
+	}
+	c.logger.Debug("stopped")
+}
+
+// RunOnce sends the digest right away.
+func (c *Controller) RunOnce() error {
+	var cfg DigestConfig
+	if err := config.Process("sindico_digest", &cfg); err != nil {
+		return err
+	}
+	return c.digest(&cfg)
+}
+
+func (c *Controller) digest(cfg *DigestConfig) error {
+	to := time.Now()
+	from := to.AddDate(0, 0, -cfg.Days)
+	src, err := c.source(cfg)
+	if err != nil {
+		return err
+	}
+	r := build(c.k8s.Cluster(), from, to, src)
+	if err := c.upload(cfg, r); err != nil {
+		return err
+	}
+	var errs []error
+	for _, t := range r.Teams {
+		m := t.message(r)
+		if t.Team != "" && !c.nt.Routed(m.Meta, cfg.NotificationChannel) {
+			// only team channels or routes take the summaries to the teams
+			c.logger.Warn("team without notification channel, sending its digest to the default one",
+				"team", t.Team, "channel", cfg.NotificationChannel)
+		}
+		if err := c.nt.Send(m, cfg.NotificationChannel); err != nil {
+			c.logger.Error("failed to post message", "team", t.Team, "err", err)
+			errs = append(errs, errors.Wrap(err, "failed to post message"))
+		}
+	}
+	if err := c.nt.Send(r.message(), cfg.NotificationChannel); err != nil {
+		c.logger.Error("failed to post message", "err", err)
+		errs = append(errs, errors.Wrap(err, "failed to post message"))
+	}
+	c.logger.Info("digest sent", "teams", len(r.Teams))
+	return utilerrors.NewAggregate(errs)
+}
+
+func (c *Controller) source(cfg *DigestConfig) (*source, error) {
+	cluster := c.k8s.Cluster()
+	// one more day as the files are daily and the period ends now
+	events, err := c.hist.Query(cfg.Days+1, &history.Filter{Cluster: cluster})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read history")
+	}
+	records, err := c.aud.Query(cfg.Days+1, &audit.Filter{Cluster: cluster})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read audit log")
+	}
+	teams := make(map[string]string)
+	return &source{
+		events:   events,
+		records:  records,
+		crashes:  c.nt.Alerts(notification.Meta{Controller: "kubewatch", Condition: "CrashLoopBackOff"}),
+		services: c.nt.Alerts(notification.Meta{Controller: "watchdog/service", Condition: "NoFirewall"}),
+		team: func(ns string) string {
+			team, found := teams[ns]
+			if !found {
+				var err error
+				if team, err = c.k8s.GetLabelValue(ns, cfg.TeamNsLabel); err != nil {
+					c.logger.Debug("failed to get namespace label", "ns", ns, "err", err)
+				}
+				teams[ns] = team
+			}
+			return team
+		},
+	}, nil
+}
+
+// upload keeps a JSON and an HTML copy of the report, in a directory per
+// cluster when the cluster is named.
+func (c *Controller) upload(cfg *DigestConfig, r *Report) error {
+	dir := cfg.Dir
+	if r.Cluster != "" {
+		dir = fmt.Sprintf("%s/%s", dir, r.Cluster)
+	}
+	name := fmt.Sprintf("%s/digest-%s", dir, r.To.Format(dayFormat))
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode digest")
+	}
+	if err := c.st.UploadFile(name+".json", bytes.NewReader(data)); err != nil {
+		return errors.Wrap(err, "failed to upload digest")
+	}
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, r); err != nil {
+		return errors.Wrap(err, "failed to render digest")
+	}
+	if err := c.st.UploadFile(name+".html", bytes.NewReader(buf.Bytes())); err != nil {
+		return errors.Wrap(err, "failed to upload digest")
+	}
+	c.logger.Debug("uploaded", "fname", name)
+	return nil
+}
+
+var htmlTemplate = template.Must(template.New("digest").Funcs(template.FuncMap{
+	"day":   func(t time.Time) string { return t.Format(dayFormat) },
+	"bytes": bytesize,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Cluster health digest</title></head>
+<body>
+<h1>Cluster health digest{{if .Cluster}} of {{.Cluster}}{{end}}</h1>
+<p>From {{day .From}} to {{day .To}}</p>
+<h2>Etcd backups</h2>
+{{with .Backups}}{{if .Total}}<p>{{.SuccessRate}}% of {{.Total}} backups succeeded, sizes: last {{bytes .LastSize}}, avg {{bytes .AvgSize}}, min {{bytes .MinSize}}, max {{bytes .MaxSize}}</p>
+{{else}}<p>No backups</p>
+{{end}}{{end}}{{range .Teams}}<h2>{{if .Team}}Team {{.Team}}{{else}}Without team{{end}}</h2>
+{{if .Crashes}}<h3>CrashLoopBackOff</h3>
+<table>
+<tr><th>Namespace</th><th>Since</th><th>Duration</th></tr>
+{{range .Crashes}}<tr><td>{{.Namespace}}</td><td>{{.Since.Format "2006-01-02 15:04"}}</td><td>{{.Duration}}{{if .Ongoing}} (ongoing){{end}}</td></tr>
+{{end}}</table>
+{{end}}{{if .NotReady}}<h3>Not ready pods</h3>
+<table>
+<tr><th>Namespace</th><th>Avg</th><th>Max</th><th>Daily max</th></tr>
+{{range .NotReady}}<tr><td>{{.Namespace}}</td><td>{{.Avg}}%</td><td>{{.Max}}%</td><td>{{range .Daily}}{{.Day}}: {{.Max}}% {{end}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Clamps}}<h3>Watchdog clamps</h3>
+<table>
+<tr><th>Namespace</th><th>Subcontroller</th><th>Changes</th></tr>
+{{range .Clamps}}<tr><td>{{.Namespace}}</td><td>{{.Actor}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Services}}<h3>LoadBalancers without firewall</h3>
+<table>
+<tr><th>Namespace</th><th>Service</th><th>Since</th></tr>
+{{range .Services}}<tr><td>{{.Namespace}}</td><td>{{.Name}}</td><td>{{.Since.Format "2006-01-02 15:04"}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}</body>
+</html>
+`))
@@ -0,0 +1,319 @@
+package digest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/luizalabs/sindico/audit"
+	"github.com/luizalabs/sindico/history"
+	"github.com/luizalabs/sindico/notification"
+)
+
+const dayFormat = "2006-01-02"
+
+// Report is what was observed on a cluster between From and To.
+type Report struct {
+	Cluster string        `json:"cluster,omitempty"`
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Teams   []*TeamReport `json:"teams"`
+	Backups *BackupReport `json:"backups"`
+}
+
+// TeamReport groups the namespaces of a team, Team is empty for the
+// namespaces without one.
+type TeamReport struct {
+	Team     string     `json:"team"`
+	Crashes  []Crash    `json:"crashes,omitempty"`
+	NotReady []NotReady `json:"not_ready,omitempty"`
+	Clamps   []Clamp    `json:"clamps,omitempty"`
+	Services []Service  `json:"services,omitempty"`
+}
+
+// Crash is a period a namespace had pods in CrashLoopBackOff, Ongoing if it
+// didn't end by the time of the report.
+type Crash struct {
+	Namespace string    `json:"namespace"`
+	Since     time.Time `json:"since"`
+	Until     time.Time `json:"until"`
+	Ongoing   bool      `json:"ongoing,omitempty"`
+}
+
+func (c Crash) Duration() time.Duration {
+	return c.Until.Sub(c.Since).Round(time.Minute)
+}
+
+// NotReady summarizes the samples of a namespace with not ready pods.
+type NotReady struct {
+	Namespace string  `json:"namespace"`
+	Samples   int     `json:"samples"`
+	Avg       float64 `json:"avg"`
+	Max       float64 `json:"max"`
+	Daily     []Daily `json:"daily"`
+}
+
+type Daily struct {
+	Day string  `json:"day"`
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+}
+
+// Clamp counts the changes made by a watchdog subcontroller to a namespace.
+type Clamp struct {
+	Namespace string `json:"namespace"`
+	Actor     string `json:"actor"`
+	Count     int    `json:"count"`
+}
+
+// Service is a LoadBalancer still without firewall rules.
+type Service struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Since     time.Time `json:"since"`
+}
+
+type BackupReport struct {
+	Total       int        `json:"total"`
+	Failed      int        `json:"failed"`
+	SuccessRate float64    `json:"success_rate"`
+	AvgSize     int64      `json:"avg_size"`
+	MinSize     int64      `json:"min_size"`
+	MaxSize     int64      `json:"max_size"`
+	LastSize    int64      `json:"last_size"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// source is everything a report is built from.
+type source struct {
+	events   []history.Event
+	records  []audit.Record
+	crashes  []notification.Alert
+	services []notification.Alert
+	team     func(ns string) string
+}
+
+func build(cluster string, from, to time.Time, src *source) *Report {
+	r := &Report{Cluster: cluster, From: from, To: to, Backups: &BackupReport{}}
+	teams := make(map[string]*TeamReport)
+	teamOf := func(team string) *TeamReport {
+		t, found := teams[team]
+		if !found {
+			t = &TeamReport{Team: team}
+			teams[team] = t
+		}
+		return t
+	}
+	notReady := make(map[string][]history.Event)
+	for _, e := range src.events {
+		if e.Time.Before(from) {
+			continue
+		}
+		switch e.Kind {
+		case history.KindCrash:
+			if e.Since == nil {
+				continue
+			}
+			t := teamOf(e.Team)
+			t.Crashes = append(t.Crashes, Crash{Namespace: e.Namespace, Since: *e.Since, Until: e.Time})
+		case history.KindNotReady:
+			key := e.Team + "/" + e.Namespace
+			notReady[key] = append(notReady[key], e)
+		case history.KindBackup:
+			r.Backups.add(e)
+		}
+	}
+	for _, a := range src.crashes {
+		t := teamOf(a.Team)
+		t.Crashes = append(t.Crashes, Crash{Namespace: a.Namespace, Since: a.Since, Until: to, Ongoing: true})
+	}
+	for _, events := range notReady {
+		t := teamOf(events[0].Team)
+		t.NotReady = append(t.NotReady, summarize(events))
+	}
+	clamps := make(map[string]*Clamp)
+	for _, rec := range src.records {
+		if rec.Time.Before(from) || !strings.HasPrefix(rec.Actor, "watchdog/") {
+			continue
+		}
+		key := rec.Namespace + "/" + rec.Actor
+		c, found := clamps[key]
+		if !found {
+			c = &Clamp{Namespace: rec.Namespace, Actor: rec.Actor}
+			clamps[key] = c
+		}
+		c.Count++
+	}
+	for _, c := range clamps {
+		t := teamOf(src.team(c.Namespace))
+		t.Clamps = append(t.Clamps, *c)
+	}
+	for _, a := range src.services {
+		t := teamOf(a.Team)
+		t.Services = append(t.Services, Service{Namespace: a.Namespace, Name: a.Object, Since: a.Since})
+	}
+	r.Backups.finish()
+	for _, t := range teams {
+		t.sort()
+		r.Teams = append(r.Teams, t)
+	}
+	sort.Slice(r.Teams, func(i, j int) bool { return r.Teams[i].Team < r.Teams[j].Team })
+	return r
+}
+
+// summarize weights the average of each event by its samples, events
+// recorded before the samples were batched are single samples.
+func summarize(events []history.Event) NotReady {
+	n := NotReady{Namespace: events[0].Namespace}
+	type total struct {
+		sum, max float64
+		samples  int
+	}
+	all := &total{}
+	days := make(map[string]*total)
+	for _, e := range events {
+		samples, max := e.Samples, e.Max
+		if samples == 0 {
+			samples, max = 1, e.Value
+		}
+		day := e.Time.Format(dayFormat)
+		if days[day] == nil {
+			days[day] = &total{}
+		}
+		for _, t := range []*total{all, days[day]} {
+			t.sum += e.Value * float64(samples)
+			t.samples += samples
+			if max > t.max {
+				t.max = max
+			}
+		}
+	}
+	n.Samples, n.Avg, n.Max = all.samples, round(all.sum/float64(all.samples)), all.max
+	for day, t := range days {
+		n.Daily = append(n.Daily, Daily{Day: day, Avg: round(t.sum / float64(t.samples)), Max: t.max})
+	}
+	sort.Slice(n.Daily, func(i, j int) bool { return n.Daily[i].Day < n.Daily[j].Day })
+	return n
+}
+
+func round(f float64) float64 {
+	return float64(int64(f*10+0.5)) / 10
+}
+
+func (t *TeamReport) sort() {
+	sort.Slice(t.Crashes, func(i, j int) bool { return t.Crashes[i].Since.Before(t.Crashes[j].Since) })
+	sort.Slice(t.NotReady, func(i, j int) bool { return t.NotReady[i].Max > t.NotReady[j].Max })
+	sort.Slice(t.Clamps, func(i, j int) bool {
+		if t.Clamps[i].Namespace != t.Clamps[j].Namespace {
+			return t.Clamps[i].Namespace < t.Clamps[j].Namespace
+		}
+		return t.Clamps[i].Actor < t.Clamps[j].Actor
+	})
+	sort.Slice(t.Services, func(i, j int) bool {
+		return t.Services[i].Namespace+"/"+t.Services[i].Name < t.Services[j].Namespace+"/"+t.Services[j].Name
+	})
+}
+
+func (b *BackupReport) add(e history.Event) {
+	b.Total++
+	if e.Error != "" {
+		b.Failed++
+		return
+	}
+	size := int64(e.Value)
+	if b.MinSize == 0 || size < b.MinSize {
+		b.MinSize = size
+	}
+	if size > b.MaxSize {
+		b.MaxSize = size
+	}
+	b.AvgSize += size
+	b.LastSize = size
+	t := e.Time
+	b.LastSuccess = &t
+}
+
+func (b *BackupReport) finish() {
+	if ok := b.Total - b.Failed; ok > 0 {
+		b.AvgSize /= int64(ok)
+	}
+	if b.Total > 0 {
+		b.SuccessRate = round(float64(b.Total-b.Failed) * 100 / float64(b.Total))
+	}
+}
+
+// message is the summary of the team posted to its channel.
+func (t *TeamReport) message(r *Report) *notification.Message {
+	m := &notification.Message{
+		Meta:  notification.Meta{Team: t.Team, Severity: notification.SeverityInfo},
+		Title: "Cluster health digest",
+		Text:  period(r),
+	}
+	if t.Team != "" {
+		m.AddField("Team", "@"+t.Team)
+	}
+	crashes := make([]string, 0, len(t.Crashes))
+	for _, c := range t.Crashes {
+		s := fmt.Sprintf("%s for %s", c.Namespace, c.Duration())
+		if c.Ongoing {
+			s += " (ongoing)"
+		}
+		crashes = append(crashes, s)
+	}
+	m.AddField("CrashLoopBackOff", strings.Join(crashes, "\n"))
+	notReady := make([]string, 0, len(t.NotReady))
+	for _, n := range t.NotReady {
+		notReady = append(notReady, fmt.Sprintf("%s avg %g%% max %g%%", n.Namespace, n.Avg, n.Max))
+	}
+	m.AddField("Not ready", strings.Join(notReady, "\n"))
+	clamps := make([]string, 0, len(t.Clamps))
+	for _, c := range t.Clamps {
+		clamps = append(clamps, fmt.Sprintf("%s by %s: %d", c.Namespace, c.Actor, c.Count))
+	}
+	m.AddField("Clamps", strings.Join(clamps, "\n"))
+	services := make([]string, 0, len(t.Services))
+	for _, s := range t.Services {
+		services = append(services, s.Namespace+"/"+s.Name)
+	}
+	m.AddField("Without firewall", strings.Join(services, "\n"))
+	return m
+}
+
+// message is the summary of the cluster posted to the default channel.
+func (r *Report) message() *notification.Message {
+	m := &notification.Message{
+		Meta:  notification.Meta{Severity: notification.SeverityInfo},
+		Title: "Cluster health digest",
+		Text:  period(r),
+	}
+	m.AddField("Teams with issues", fmt.Sprint(len(r.Teams)))
+	b := r.Backups
+	if b.Total == 0 {
+		m.AddField("Etcd backups", "none")
+		return m
+	}
+	m.AddField("Etcd backups", fmt.Sprintf("%d of %d succeeded (%g%%)", b.Total-b.Failed, b.Total, b.SuccessRate))
+	if b.LastSuccess != nil {
+		m.AddField("Backup size", fmt.Sprintf("last %s, avg %s, min %s, max %s",
+			bytesize(b.LastSize), bytesize(b.AvgSize), bytesize(b.MinSize), bytesize(b.MaxSize)))
+	}
+	return m
+}
+
+func period(r *Report) string {
+	return fmt.Sprintf("From %s to %s", r.From.Format(dayFormat), r.To.Format(dayFormat))
+}
+
+func bytesize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,212 @@
+package digest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/luizalabs/sindico/audit"
+	"github.com/luizalabs/sindico/history"
+	"github.com/luizalabs/sindico/notification"
+)
+
+var (
+	to   = time.Date(2018, 8, 6, 9, 0, 0, 0, time.UTC)
+	from = to.AddDate(0, 0, -7)
+)
+
+func at(days, hours int) time.Time {
+	return from.AddDate(0, 0, days).Add(time.Duration(hours) * time.Hour)
+}
+
+func notReady(t time.Time, team, ns string, value, max float64, samples int) history.Event {
+	return history.Event{Time: t, Kind: history.KindNotReady, Team: team, Namespace: ns, Value: value, Max: max, Samples: samples}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []history.Event
+		want   NotReady
+	}{
+		{
+			name:   "single samples",
+			events: []history.Event{notReady(at(1, 0), "", "ns", 10, 0, 0), notReady(at(1, 1), "", "ns", 30, 0, 0)},
+			want: NotReady{Namespace: "ns", Samples: 2, Avg: 20, Max: 30, Daily: []Daily{
+				{Day: "2018-07-31", Avg: 20, Max: 30},
+			}},
+		},
+		{
+			name:   "batches weighted by samples",
+			events: []history.Event{notReady(at(1, 0), "", "ns", 10, 20, 3), notReady(at(2, 0), "", "ns", 50, 90, 1)},
+			want: NotReady{Namespace: "ns", Samples: 4, Avg: 20, Max: 90, Daily: []Daily{
+				{Day: "2018-07-31", Avg: 10, Max: 20},
+				{Day: "2018-08-01", Avg: 50, Max: 90},
+			}},
+		},
+		{
+			name:   "single samples and batches",
+			events: []history.Event{notReady(at(3, 0), "", "ns", 40, 0, 0), notReady(at(3, 1), "", "ns", 10, 15, 2)},
+			want: NotReady{Namespace: "ns", Samples: 3, Avg: 20, Max: 40, Daily: []Daily{
+				{Day: "2018-08-02", Avg: 20, Max: 40},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarize(tt.events); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	crashSince := at(1, 0)
+	src := &source{
+		events: []history.Event{
+			{Time: at(-1, 0), Kind: history.KindBackup, Value: 1 << 20},
+			{Time: at(1, 2), Kind: history.KindCrash, Team: "checkout", Namespace: "cart", Since: &crashSince},
+			notReady(at(2, 0), "checkout", "cart", 70, 80, 2),
+			notReady(at(2, 0), "", "misc", 10, 10, 1),
+			{Time: at(1, 0), Kind: history.KindBackup, Value: 1000},
+			{Time: at(2, 0), Kind: history.KindBackup, Error: "timeout"},
+			{Time: at(3, 0), Kind: history.KindBackup, Value: 3000},
+		},
+		records: []audit.Record{
+			{Time: at(-1, 0), Actor: "watchdog/hpa", Namespace: "cart"},
+			{Time: at(1, 0), Actor: "watchdog/hpa", Namespace: "cart"},
+			{Time: at(2, 0), Actor: "watchdog/hpa", Namespace: "cart"},
+			{Time: at(2, 0), Actor: "watchdog/limits", Namespace: "cart"},
+			{Time: at(2, 0), Actor: "srebot", Namespace: "cart"},
+		},
+		crashes: []notification.Alert{
+			{Meta: notification.Meta{Team: "search", Namespace: "solr"}, Since: at(6, 0)},
+		},
+		services: []notification.Alert{
+			{Meta: notification.Meta{Team: "checkout", Namespace: "cart", Object: "api"}, Since: at(0, 0)},
+		},
+		team: func(ns string) string {
+			if ns == "cart" {
+				return "checkout"
+			}
+			return ""
+		},
+	}
+	r := build("prod", from, to, src)
+
+	teams := make([]string, len(r.Teams))
+	for i, team := range r.Teams {
+		teams[i] = team.Team
+	}
+	if want := []string{"", "checkout", "search"}; !reflect.DeepEqual(teams, want) {
+		t.Fatalf("got teams %q, want %q", teams, want)
+	}
+	checkout := r.Teams[1]
+	wantCrashes := []Crash{{Namespace: "cart", Since: crashSince, Until: at(1, 2)}}
+	if !reflect.DeepEqual(checkout.Crashes, wantCrashes) {
+		t.Errorf("got crashes %+v, want %+v", checkout.Crashes, wantCrashes)
+	}
+	if len(checkout.NotReady) != 1 || checkout.NotReady[0].Avg != 70 || checkout.NotReady[0].Max != 80 {
+		t.Errorf("got not ready %+v, want cart avg 70 max 80", checkout.NotReady)
+	}
+	wantClamps := []Clamp{{Namespace: "cart", Actor: "watchdog/hpa", Count: 2}, {Namespace: "cart", Actor: "watchdog/limits", Count: 1}}
+	if !reflect.DeepEqual(checkout.Clamps, wantClamps) {
+		t.Errorf("got clamps %+v, want %+v", checkout.Clamps, wantClamps)
+	}
+	wantServices := []Service{{Namespace: "cart", Name: "api", Since: at(0, 0)}}
+	if !reflect.DeepEqual(checkout.Services, wantServices) {
+		t.Errorf("got services %+v, want %+v", checkout.Services, wantServices)
+	}
+	search := r.Teams[2]
+	if len(search.Crashes) != 1 || !search.Crashes[0].Ongoing || !search.Crashes[0].Until.Equal(to) {
+		t.Errorf("got crashes %+v, want an ongoing crash until the report", search.Crashes)
+	}
+
+	lastSuccess := at(3, 0)
+	wantBackups := &BackupReport{
+		Total:       3,
+		Failed:      1,
+		SuccessRate: 66.7,
+		AvgSize:     2000,
+		MinSize:     1000,
+		MaxSize:     3000,
+		LastSize:    3000,
+		LastSuccess: &lastSuccess,
+	}
+	if !reflect.DeepEqual(r.Backups, wantBackups) {
+		t.Errorf("got backups %+v, want %+v", r.Backups, wantBackups)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	r := &Report{
+		From: from,
+		To:   to,
+		Teams: []*TeamReport{{
+			Team:     "checkout",
+			Crashes:  []Crash{{Namespace: "cart", Since: at(1, 0), Until: at(1, 2)}, {Namespace: "cart", Since: at(6, 0), Until: to, Ongoing: true}},
+			NotReady: []NotReady{{Namespace: "cart", Avg: 70, Max: 80}},
+			Clamps:   []Clamp{{Namespace: "cart", Actor: "watchdog/hpa", Count: 2}},
+			Services: []Service{{Namespace: "cart", Name: "api"}},
+		}},
+		Backups: &BackupReport{Total: 3, Failed: 1, SuccessRate: 66.7, AvgSize: 2000, MinSize: 1000, MaxSize: 3000, LastSize: 3000},
+	}
+	lastSuccess := at(3, 0)
+	r.Backups.LastSuccess = &lastSuccess
+
+	fields := func(m *notification.Message) map[string]string {
+		fs := make(map[string]string)
+		for _, f := range m.Fields {
+			fs[f.Name] = f.Value
+		}
+		return fs
+	}
+	tests := []struct {
+		name string
+		m    *notification.Message
+		want map[string]string
+	}{
+		{
+			name: "team",
+			m:    r.Teams[0].message(r),
+			want: map[string]string{
+				"Team":             "@checkout",
+				"CrashLoopBackOff": "cart for 2h0m0s\ncart for 24h0m0s (ongoing)",
+				"Not ready":        "cart avg 70% max 80%",
+				"Clamps":           "cart by watchdog/hpa: 2",
+				"Without firewall": "cart/api",
+			},
+		},
+		{
+			name: "cluster",
+			m:    r.message(),
+			want: map[string]string{
+				"Teams with issues": "1",
+				"Etcd backups":      "2 of 3 succeeded (66.7%)",
+				"Backup size":       "last 2.9KiB, avg 2.0KiB, min 1000B, max 2.9KiB",
+			},
+		},
+		{
+			name: "cluster without backups",
+			m:    (&Report{From: from, To: to, Backups: &BackupReport{}}).message(),
+			want: map[string]string{
+				"Teams with issues": "0",
+				"Etcd backups":      "none",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.m.Text != "From 2018-07-30 to 2018-08-06" {
+				t.Errorf("got text %q", tt.m.Text)
+			}
+			got := fields(tt.m)
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("got %s %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
@@ -6,11 +6,17 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/kelseyhightower/envconfig"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"github.com/luizalabs/sindico/config"
+	"github.com/luizalabs/sindico/controllers"
+	"github.com/luizalabs/sindico/history"
+	"github.com/luizalabs/sindico/notification"
+	"github.com/luizalabs/sindico/scheduler"
+	"github.com/luizalabs/sindico/status"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -21,128 +27,239 @@ const (
 )
 
 type K8s interface {
+	Cluster() string
 	FindPods(namespace, labelSelector string) ([]string, error)
 	Exec(pod, container, namespace, cmd string, stderr io.Writer, stdout io.Writer) (*http.Response, error)
 }
 
 type Notification interface {
-	PostMessage(msg, channel string) error
+	Send(m *notification.Message, channel string) error
 }
 
 type Storage interface {
 	UploadFile(path string, r io.ReadSeeker) error
 }
 
+type History interface {
+	Append(events ...history.Event) error
+}
+
 type EtcdBackupConfig struct {
-	Interval            time.Duration `split_words:"true" default:"6h"`
+	Schedule            string        `split_words:"true" default:"0 */6 * * *"`
+	Interval            time.Duration `split_words:"true"`
 	Dir                 string        `split_words:"true" default:"etcd-backup"`
 	Disabled            string        `split_words:"true" default:""`
 	NotificationChannel string        `split_words:"true" default:"#alerts"`
 }
 
 type Controller struct {
-	k8s    K8s
-	st     Storage
-	nt     Notification
-	logger log.Logger
+	k8s     K8s
+	st      Storage
+	nt      Notification
+	hist    History
+	logger  log.Logger
+	status  *status.Component
+	metrics *backupMetrics
 }
 
-func NewController(k8s K8s, st Storage, nt Notification) *Controller {
-	logger := log.New("controller", "etcdbackup")
-	return &Controller{k8s: k8s, st: st, nt: nt, logger: logger}
+func NewController(k8s K8s, st Storage, nt Notification, hist History) *Controller {
+	logger := controllers.Logger(k8s.Cluster(), "controller", "etcdbackup")
+	return &Controller{
+		k8s:     k8s,
+		st:      st,
+		nt:      nt,
+		hist:    hist,
+		logger:  logger,
+		status:  status.For(k8s.Cluster(), "etcdbackup"),
+		metrics: newBackupMetrics(k8s.Cluster()),
+	}
 }
 
-func backupName(dir string) string {
+// backupName keeps the backups of each named cluster in its own directory.
+func backupName(dir, cluster string) string {
 	format := "2006-01-02_15:04:05-07:00"
+	if cluster != "" {
+		dir = fmt.Sprintf("%s/%s", dir, cluster)
+	}
 	return fmt.Sprintf("%s/etcd-backup-%s.tgz", dir, time.Now().Format(format))
 }
 
-func (c *Controller) notifyError(msg, channel string, val ...interface{}) {
+// notifyError logs and sends msg, with err and the key/values as fields, and
+// returns err wrapped with msg.
+func (c *Controller) notifyError(err error, msg, channel string, val ...interface{}) error {
+	if err != nil {
+		val = append([]interface{}{"err", err}, val...)
+	}
 	c.logger.Error(msg, val...)
-	tmp := fmt.Sprintf("*sindico etcdbackup error*: %v", val)
-	if err := c.nt.PostMessage(tmp, channel); err != nil {
-		c.logger.Error("can't send message", val...)
+	m := &notification.Message{
+		Meta:  notification.Meta{Severity: notification.SeverityCritical},
+		Title: "Etcd backup failed",
+		Text:  msg,
+	}
+	for i := 0; i+1 < len(val); i += 2 {
+		if val[i+1] != nil {
+			m.AddField(fmt.Sprint(val[i]), fmt.Sprint(val[i+1]))
+		}
+	}
+	if nerr := c.nt.Send(m, channel); nerr != nil {
+		c.logger.Error("can't send message", "err", nerr)
+	}
+	if err == nil {
+		return errors.New(msg)
+	}
+	return errors.Wrap(err, msg)
+}
+
+// execError is the error of a command run in a pod, or its stderr when it
+// wrote to it.
+func execError(err error, stderr string) error {
+	if err == nil && stderr != "" {
+		return errors.New(strings.TrimSpace(stderr))
+	}
+	return err
+}
+
+// schedule keeps the deprecated Interval setting working.
+func (c *EtcdBackupConfig) schedule() string {
+	if c.Interval > 0 {
+		return scheduler.Every(c.Interval)
 	}
+	return c.Schedule
+}
+
+func (c *EtcdBackupConfig) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must be positive, got %s", c.Interval)
+	}
+	_, _, err := scheduler.Parse(c.schedule())
+	return err
+}
+
+func init() {
+	config.Register("sindico_etcd_backup", &EtcdBackupConfig{})
+	controllers.Register("etcdbackup", func(deps *controllers.Deps) (controllers.Controller, error) {
+		return NewController(deps.K8s, deps.Storage, deps.Notification, deps.History), nil
+	})
 }
 
 func (c *Controller) Run(stopCh <-chan struct{}) {
 	var cfg EtcdBackupConfig
-	if err := envconfig.Process("sindico_etcd_backup", &cfg); err != nil {
-		c.logger.Error("failed to process env vars", "err", err)
+	if err := config.Process("sindico_etcd_backup", &cfg); err != nil {
+		c.logger.Error("failed to process config", "err", err)
+		c.status.Fail(err)
 		return
 	}
-	if cfg.Disabled != "" {
-		c.logger.Debug("disabled")
+	c.logger.Debug("starting")
+	fn := func() {
+		var newCfg EtcdBackupConfig
+		if err := config.Process("sindico_etcd_backup", &newCfg); err != nil {
+			c.logger.Error("failed to reload config", "err", err)
+		} else {
+			cfg = newCfg
+		}
+		c.status.Run(func() error {
+			start := time.Now()
+			err := c.run(&cfg)
+			c.metrics.duration.Observe(time.Since(start).Seconds())
+			if err != nil {
+				c.metrics.failures.Inc()
+			}
+			return err
+		})
+	}
+	spec := func() string { return cfg.schedule() }
+	if err := scheduler.Run(c.status, spec, fn, stopCh); err != nil {
+		c.logger.Error("invalid schedule", "err", err)
+		c.status.Fail(err)
 		return
 	}
-	c.logger.Debug("starting")
-	fn := func() { c.backup(&cfg) }
-	time.Sleep(10 * time.Minute)
-	go wait.JitterUntil(fn, cfg.Interval, 0.1, true, stopCh)
-	<-stopCh
 	c.logger.Debug("stopped")
 }
 
+// RunOnce makes a backup right away.
+func (c *Controller) RunOnce() error {
+	var cfg EtcdBackupConfig
+	if err := config.Process("sindico_etcd_backup", &cfg); err != nil {
+		return err
+	}
+	return c.run(&cfg)
+}
+
+// run makes a backup and records it in the history for the digests.
+func (c *Controller) run(cfg *EtcdBackupConfig) error {
+	size, err := c.backup(cfg)
+	e := history.Event{Time: time.Now(), Cluster: c.k8s.Cluster(), Kind: history.KindBackup, Value: float64(size)}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if herr := c.hist.Append(e); herr != nil {
+		c.logger.Error("failed to record backup", "err", herr)
+	}
+	return err
+}
+
 func (c *Controller) cleanup(cfg *EtcdBackupConfig, pod string) {
 	var stderr bytes.Buffer
 	_, err := c.k8s.Exec(pod, "", kubeNamespace, cleanupCmd, &stderr, nil)
 	resp := stderr.String()
-	if err != nil || resp != "" {
+	if err := execError(err, resp); err != nil {
 		c.notifyError(
+			err,
 			"dir cleaning failed",
 			cfg.NotificationChannel,
-			"err", err,
 			"pod", pod,
 			"stderr", resp,
 		)
 	}
 }
 
-func (c *Controller) backup(cfg *EtcdBackupConfig) {
+func (c *Controller) backup(cfg *EtcdBackupConfig) (int, error) {
 	pods, err := c.k8s.FindPods(kubeNamespace, "k8s-app=etcd-server")
 	if err != nil {
-		c.notifyError("find pods failed", cfg.NotificationChannel, "err", err)
-		return
+		return 0, c.notifyError(err, "find pods failed", cfg.NotificationChannel)
+	}
+	if len(pods) == 0 {
+		return 0, c.notifyError(nil, "no etcd pods found", cfg.NotificationChannel, "namespace", kubeNamespace)
 	}
 	n := rand.Intn(len(pods))
 	pod := string(pods[n])
 	var stderr, stdout bytes.Buffer
 	_, err = c.k8s.Exec(pod, "", kubeNamespace, backupCmd, &stderr, nil)
 	resp := stderr.String()
-	if err != nil || resp != "" {
-		c.notifyError(
+	if err := execError(err, resp); err != nil {
+		return 0, c.notifyError(
+			err,
 			"backup failed",
 			cfg.NotificationChannel,
-			"err", err,
 			"pod", pod,
 			"stderr", resp,
 		)
-		return
 	}
 	defer c.cleanup(cfg, pod)
 	stderr.Reset()
 	_, err = c.k8s.Exec(pod, "", kubeNamespace, fetchCmd, &stderr, &stdout)
 	resp = stderr.String()
-	if err != nil || resp != "" {
-		c.notifyError(
+	if err := execError(err, resp); err != nil {
+		return 0, c.notifyError(
+			err,
 			"tar creation failed",
 			cfg.NotificationChannel,
-			"err", err,
 			"pod", pod,
 			"stderr", resp,
 		)
-		return
 	}
 	r := bytes.NewReader(stdout.Bytes())
-	fname := backupName(cfg.Dir)
+	fname := backupName(cfg.Dir, c.k8s.Cluster())
 	if err := c.st.UploadFile(fname, r); err != nil {
-		c.notifyError(
+		return 0, c.notifyError(
+			err,
 			"upload failed",
 			cfg.NotificationChannel,
-			"err", err,
 			"pod", pod,
 		)
-		return
 	}
+	c.metrics.size.Set(float64(stdout.Len()))
+	c.metrics.lastSuccess.Set(float64(time.Now().Unix()))
 	c.logger.Debug("done", "fname", fname)
+	return stdout.Len(), nil
 }
@@ -0,0 +1,47 @@
+package etcdbackup
+
+import (
+	"github.com/luizalabs/sindico/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type backupMetrics struct {
+	duration    prometheus.Histogram
+	size        prometheus.Gauge
+	lastSuccess prometheus.Gauge
+	failures    prometheus.Counter
+}
+
+func newBackupMetrics(cluster string) *backupMetrics {
+	return &backupMetrics{
+		duration: metrics.Register(prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   metrics.Namespace,
+			Subsystem:   "etcdbackup",
+			Name:        "duration_seconds",
+			Help:        "Time taken to backup etcd and upload the file.",
+			ConstLabels: metrics.ClusterLabels(cluster),
+			Buckets:     prometheus.ExponentialBuckets(1, 2, 10),
+		})).(prometheus.Histogram),
+		size: metrics.Register(prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   metrics.Namespace,
+			Subsystem:   "etcdbackup",
+			Name:        "size_bytes",
+			Help:        "Size of the last uploaded backup.",
+			ConstLabels: metrics.ClusterLabels(cluster),
+		})).(prometheus.Gauge),
+		lastSuccess: metrics.Register(prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   metrics.Namespace,
+			Subsystem:   "etcdbackup",
+			Name:        "last_success_timestamp_seconds",
+			Help:        "Unix time of the last successful backup.",
+			ConstLabels: metrics.ClusterLabels(cluster),
+		})).(prometheus.Gauge),
+		failures: metrics.Register(prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   metrics.Namespace,
+			Subsystem:   "etcdbackup",
+			Name:        "failures_total",
+			Help:        "Number of failed backups.",
+			ConstLabels: metrics.ClusterLabels(cluster),
+		})).(prometheus.Counter),
+	}
+}
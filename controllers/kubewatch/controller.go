@@ -3,11 +3,22 @@ package kubewatch
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"time"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/kelseyhightower/envconfig"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"github.com/luizalabs/sindico/config"
+	"github.com/luizalabs/sindico/history"
+	"github.com/luizalabs/sindico/k8s"
+	"github.com/luizalabs/sindico/notification"
+	"github.com/luizalabs/sindico/scheduler"
+	"github.com/luizalabs/sindico/status"
+	"github.com/pkg/errors"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
 )
 
 type Pod struct {
@@ -15,115 +26,325 @@ type Pod struct {
 	Namespace string
 	Status    string
 	Ready     bool
+	container string
+	obj       *k8sv1.Pod
 }
 
+// notReadyBatch is how long the not ready samples are kept in memory
+// before being added to the history.
+const notReadyBatch = time.Hour
+
 type KubeWatch struct {
-	k      K8s
-	n      Notification
-	logger log.Logger
+	k        K8s
+	pods     corelisters.PodLister
+	n        Notification
+	hist     History
+	logger   log.Logger
+	status   *status.Component
+	metrics  *watchMetrics
+	notReady *notReadySamples
+}
+
+// notReadySamples are the percentages of not ready pods of each namespace
+// since start.
+type notReadySamples struct {
+	start  time.Time
+	last   time.Time
+	sum    map[string]float64
+	max    map[string]float64
+	counts map[string]int
 }
 
 func (kw *KubeWatch) Run(stopCh <-chan struct{}) {
-	var cfg KubeWatchConfig
-	if err := envconfig.Process("sindico_kube_watch", &cfg); err != nil {
-		kw.logger.Error("failed to process env vars", "err", err)
-		return
-	}
-	re, err := regexp.Compile(cfg.IgnoreNsRegexp)
+	cfg, re, err := loadConfig()
 	if err != nil {
-		kw.logger.Error("invalid ignore regex", "err", err)
+		kw.logger.Error("invalid config", "err", err)
+		kw.status.Fail(err)
 		return
 	}
 	kw.logger.Debug("starting")
 	fn := func() {
-		kw.checkCrashedPods(&cfg, re)
-		kw.checkNotReadyPods(&cfg, re)
+		if newCfg, newRe, err := loadConfig(); err != nil {
+			kw.logger.Error("failed to reload config", "err", err)
+		} else {
+			cfg, re = newCfg, newRe
+		}
+		kw.status.Run(func() error { return kw.check(cfg, re) })
+	}
+	spec := func() string { return cfg.schedule() }
+	if err := scheduler.Run(kw.status, spec, fn, stopCh); err != nil {
+		kw.logger.Error("invalid schedule", "err", err)
+		kw.status.Fail(err)
+		return
 	}
-	time.Sleep(5 * time.Minute)
-	go wait.JitterUntil(fn, time.Duration(cfg.CircleTime)*time.Minute, 0.1, true, stopCh)
-	<-stopCh
+	kw.flushNotReady(cfg)
 	kw.logger.Debug("stopped")
 }
 
-func (kw *KubeWatch) checkCrashedPods(cfg *KubeWatchConfig, re *regexp.Regexp) {
-	podList, err := kw.k.List("")
+// RunOnce checks the pods right away.
+func (kw *KubeWatch) RunOnce() error {
+	cfg, re, err := loadConfig()
 	if err != nil {
-		kw.propagateMsg(
-			fmt.Sprintf(":bomb: Error on check pods status: *%v*", err),
-			cfg.NotificationChannel,
-		)
-		return
+		return err
 	}
+	defer kw.flushNotReady(cfg)
+	return kw.check(cfg, re)
+}
 
+func (kw *KubeWatch) check(cfg *KubeWatchConfig, re *regexp.Regexp) error {
+	podList, err := kw.listPods(cfg)
+	if err != nil {
+		return err
+	}
+	return utilerrors.NewAggregate([]error{
+		kw.checkCrashedPods(cfg, re, podList),
+		kw.checkNotReadyPods(cfg, re, podList),
+	})
+}
+
+func loadConfig() (*KubeWatchConfig, *regexp.Regexp, error) {
+	var cfg KubeWatchConfig
+	if err := config.Process("sindico_kube_watch", &cfg); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to process config")
+	}
+	re, err := regexp.Compile(cfg.IgnoreNsRegexp)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid ignore regex")
+	}
+	return &cfg, re, nil
+}
+
+func (kw *KubeWatch) listPods(cfg *KubeWatchConfig) ([]Pod, error) {
+	items, err := kw.pods.List(labels.Everything())
+	if err != nil {
+		kw.propagateMsg(&notification.Message{
+			Meta:  notification.Meta{Severity: notification.SeverityCritical},
+			Title: "Failed to check pods status",
+			Text:  err.Error(),
+		}, cfg.NotificationChannel)
+		return nil, errors.Wrap(err, "failed to list pods")
+	}
+	return convertPodList(items), nil
+}
+
+func (kw *KubeWatch) checkCrashedPods(cfg *KubeWatchConfig, re *regexp.Regexp, podList []Pod) error {
 	podsInCrash := groupByNamespace(podList, re, kw.logger)
 	filterCrashedsPods(podsInCrash)
-	if len(podsInCrash) == 0 {
-		return
+	kw.metrics.crashed.Reset()
+	for ns, pods := range podsInCrash {
+		kw.metrics.crashed.WithLabelValues(ns).Set(float64(len(pods)))
 	}
 
-	msg := fmt.Sprintf(":shit: *PODS IN CRASH* on _%s_:\n\n", cfg.K8sEnv)
+	alerts := make(map[string]*notification.Message)
 	for ns, pods := range podsInCrash {
 		team, err := kw.k.GetLabelValue(ns, cfg.TeamNsAnnotation)
 		if err != nil {
-			kw.propagateMsg(
-				fmt.Sprintf(":bomb: Error getting namespace label: *%v*", err),
-				cfg.NotificationChannel,
-			)
-			return
+			kw.labelError(ns, notification.SeverityCritical, err, cfg)
+			return errors.Wrap(err, "failed to get namespace label")
 		}
-		msg = fmt.Sprintf(
-			"%s*%s*: (@%s) *%d* Pod(s) in CrashLoopBackOff\n",
-			msg, ns, team, len(pods))
+		m := kw.alert(cfg, ns, team, notification.SeverityCritical, "CrashLoopBackOff", "Pods in CrashLoopBackOff")
+		m.AddField("Pods", fmt.Sprint(len(pods)))
+		alerts[ns] = m
 	}
+	fired, err := kw.syncAlerts("CrashLoopBackOff", alerts, cfg.NotificationChannel)
+	// the events are recorded along with the notifications, not on every run
+	for ns := range fired {
+		for _, pod := range podsInCrash[ns] {
+			if pod.obj != nil {
+				kw.k.Eventf(pod.obj, k8sv1.EventTypeWarning, k8s.ReasonCrashLoopBackOff,
+					"Container %s in CrashLoopBackOff", pod.container)
+			}
+		}
+	}
+	return err
+}
 
-	if err = kw.propagateMsg(msg, cfg.NotificationChannel); err != nil {
-		fmt.Println("Error on post msg on slack: ", err)
+func (kw *KubeWatch) checkNotReadyPods(cfg *KubeWatchConfig, re *regexp.Regexp, podList []Pod) error {
+	podsByNamespace := groupByNamespace(podList, re, kw.logger)
+	kw.metrics.notReady.Reset()
+	for ns, pods := range podsByNamespace {
+		kw.metrics.notReady.WithLabelValues(ns).Set(float64(countNotReady(pods)))
+	}
+	kw.recordNotReady(cfg, podsNotReadyByThreshold(podsByNamespace, 1))
+	namespaceWithNotReadyPods := podsNotReadyByThreshold(podsByNamespace, cfg.NotReadyThreshold)
+	alerts := make(map[string]*notification.Message)
+	for ns, perc := range namespaceWithNotReadyPods {
+		team, err := kw.k.GetLabelValue(ns, cfg.TeamNsAnnotation)
+		if err != nil {
+			kw.labelError(ns, notification.SeverityWarning, err, cfg)
+		}
+		m := kw.alert(cfg, ns, team, notification.SeverityWarning, "NotReady", "High number of pods not ready")
+		m.AddField("Not ready", fmt.Sprintf("%d%%", perc))
+		alerts[ns] = m
 	}
+	_, err := kw.syncAlerts("NotReady", alerts, cfg.NotificationChannel)
+	return err
 }
 
-func (kw *KubeWatch) checkNotReadyPods(cfg *KubeWatchConfig, re *regexp.Regexp) {
-	podList, err := kw.k.List("")
-	if err != nil {
-		kw.propagateMsg(
-			fmt.Sprintf(":bomb: Error on check pods status: *%v*", err),
-			cfg.NotificationChannel,
-		)
-		return
+func (kw *KubeWatch) alert(cfg *KubeWatchConfig, ns, team, severity, condition, title string) *notification.Message {
+	m := &notification.Message{
+		Meta: notification.Meta{
+			Namespace: ns,
+			Team:      team,
+			Severity:  severity,
+			Condition: condition,
+		},
+		Title: title,
+	}
+	m.AddField("Namespace", ns)
+	if team != "" {
+		m.AddField("Team", "@"+team)
+	}
+	if kw.k.Cluster() == "" {
+		m.AddField("Environment", cfg.K8sEnv)
 	}
+	return m
+}
 
-	podsByNamespace := groupByNamespace(podList, re, kw.logger)
-	namespaceWithNotReadyPods := podsNotReadyByThreshold(podsByNamespace, cfg.NotReadyThreshold)
-	if len(namespaceWithNotReadyPods) == 0 {
+// recordNotReady keeps a sample of the namespaces with not ready pods for
+// the digests. Samples are added to the history once per notReadyBatch,
+// rather than rewriting the day file on every check.
+func (kw *KubeWatch) recordNotReady(cfg *KubeWatchConfig, percs map[string]int) {
+	now := time.Now()
+	// a batch doesn't span two days of the history
+	if s := kw.notReady; s != nil && (now.Sub(s.start) >= notReadyBatch || now.UTC().Day() != s.start.UTC().Day()) {
+		kw.flushNotReady(cfg)
+	}
+	if kw.notReady == nil {
+		kw.notReady = &notReadySamples{
+			start:  now,
+			sum:    make(map[string]float64),
+			max:    make(map[string]float64),
+			counts: make(map[string]int),
+		}
+	}
+	s := kw.notReady
+	s.last = now
+	for ns, perc := range percs {
+		v := float64(perc)
+		s.sum[ns] += v
+		s.counts[ns]++
+		if v > s.max[ns] {
+			s.max[ns] = v
+		}
+	}
+}
+
+// flushNotReady adds the average and maximum of the samples of each
+// namespace to the history.
+func (kw *KubeWatch) flushNotReady(cfg *KubeWatchConfig) {
+	s := kw.notReady
+	if s == nil {
 		return
 	}
+	kw.notReady = nil
+	events := make([]history.Event, 0, len(s.counts))
+	for ns, n := range s.counts {
+		team, _ := kw.k.GetLabelValue(ns, cfg.TeamNsAnnotation)
+		events = append(events, history.Event{
+			Time:      s.last,
+			Cluster:   kw.k.Cluster(),
+			Kind:      history.KindNotReady,
+			Namespace: ns,
+			Team:      team,
+			Value:     s.sum[ns] / float64(n),
+			Max:       s.max[ns],
+			Samples:   n,
+		})
+	}
+	if err := kw.hist.Append(events...); err != nil {
+		kw.logger.Error("failed to record not ready pods", "err", err)
+	}
+}
 
-	msg := fmt.Sprintf(":warning: *NAMESPACES WITH HIGH NUMBER OF PODS NOT READY* on _%s_:\n\n", cfg.K8sEnv)
-	for ns, perc := range namespaceWithNotReadyPods {
-		team, err := kw.k.GetLabelValue(ns, cfg.TeamNsAnnotation)
+func (kw *KubeWatch) labelError(ns, severity string, err error, cfg *KubeWatchConfig) {
+	kw.propagateMsg(&notification.Message{
+		Meta:  notification.Meta{Namespace: ns, Severity: severity},
+		Title: "Failed to get namespace label",
+		Text:  err.Error(),
+	}, cfg.NotificationChannel)
+}
+
+// syncAlerts sends one message per namespace, so each is deduplicated on
+// its own, and resolves the alerts of condition that cleared. It returns
+// the namespaces whose alerts were sent.
+func (kw *KubeWatch) syncAlerts(condition string, alerts map[string]*notification.Message, channel string) (map[string]bool, error) {
+	namespaces := make([]string, 0, len(alerts))
+	for ns := range alerts {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	fired := make(map[string]bool)
+	var errs []error
+	for _, ns := range namespaces {
+		ok, err := kw.n.Fire(alerts[ns], channel)
 		if err != nil {
-			kw.propagateMsg(
-				fmt.Sprintf(":bomb: Error getting namespace label: *%v*", err),
-				cfg.NotificationChannel,
-			)
+			kw.logger.Error("failed to post message", "ns", ns, "err", err)
+			errs = append(errs, errors.Wrap(err, "failed to post message"))
+		}
+		if ok {
+			fired[ns] = true
 		}
-		msg = fmt.Sprintf("%s*%s*: (@%s) *%d %%* of pods Not Ready\n", msg, ns, team, perc)
 	}
+	for _, a := range kw.n.Alerts(notification.Meta{Condition: condition}) {
+		if _, found := alerts[a.Namespace]; found {
+			continue
+		}
+		kw.logger.Info("alert resolved", "ns", a.Namespace, "condition", condition)
+		if condition == "CrashLoopBackOff" {
+			kw.recordCrash(a)
+		}
+		if err := kw.n.Resolve(a.Meta, channel); err != nil {
+			kw.logger.Error("failed to post message", "ns", a.Namespace, "err", err)
+			errs = append(errs, errors.Wrap(err, "failed to post message"))
+		}
+	}
+	return fired, utilerrors.NewAggregate(errs)
+}
 
-	if err = kw.propagateMsg(msg, cfg.NotificationChannel); err != nil {
-		fmt.Println("Error on post msg on slack: ", err)
+// recordCrash keeps the period a namespace was in CrashLoopBackOff for the
+// digests.
+func (kw *KubeWatch) recordCrash(a notification.Alert) {
+	since := a.Since
+	e := history.Event{
+		Time:      time.Now(),
+		Cluster:   a.Cluster,
+		Kind:      history.KindCrash,
+		Namespace: a.Namespace,
+		Team:      a.Team,
+		Since:     &since,
+	}
+	if err := kw.hist.Append(e); err != nil {
+		kw.logger.Error("failed to record crash", "ns", a.Namespace, "err", err)
 	}
 }
 
+func convertPodList(items []*k8sv1.Pod) []Pod {
+	pods := make([]Pod, 0)
+	for _, pod := range items {
+		for _, status := range pod.Status.ContainerStatuses {
+			state := "Running"
+			if status.State.Waiting != nil {
+				state = status.State.Waiting.Reason
+			} else if status.State.Terminated != nil {
+				state = status.State.Terminated.Reason
+			}
+			pods = append(pods, Pod{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+				Status:    state,
+				Ready:     status.Ready,
+				container: status.Name,
+				obj:       pod})
+		}
+	}
+	return pods
+}
+
 func podsNotReadyByThreshold(items map[string][]Pod, threshold int) map[string]int {
 	result := make(map[string]int)
 	for ns, pods := range items {
-		count := 0
-		for _, pod := range pods {
-			if pod.Status == "Running" && !pod.Ready {
-				count++
-			}
-		}
-		percNotReady := (count * 100) / len(pods)
+		percNotReady := (countNotReady(pods) * 100) / len(pods)
 		if percNotReady >= threshold {
 			result[ns] = percNotReady
 		}
@@ -131,9 +352,18 @@ func podsNotReadyByThreshold(items map[string][]Pod, threshold int) map[string]i
 	return result
 }
 
-func (kw *KubeWatch) propagateMsg(msg, channel string) error {
-	fmt.Println(msg)
-	return kw.n.PostMessage(msg, channel)
+func countNotReady(pods []Pod) int {
+	count := 0
+	for _, pod := range pods {
+		if pod.Status == "Running" && !pod.Ready {
+			count++
+		}
+	}
+	return count
+}
+
+func (kw *KubeWatch) propagateMsg(m *notification.Message, channel string) error {
+	return kw.n.Send(m, channel)
 }
 
 func filterCrashedsPods(items map[string][]Pod) {
@@ -169,6 +399,14 @@ func groupByNamespace(items []Pod, re *regexp.Regexp, logger log.Logger) map[str
 	return result
 }
 
-func New(k K8s, n Notification, logger log.Logger) *KubeWatch {
-	return &KubeWatch{k: k, n: n, logger: logger}
+func New(k K8s, n Notification, hist History, logger log.Logger, st *status.Component) *KubeWatch {
+	return &KubeWatch{
+		k:       k,
+		pods:    k.Pods(),
+		n:       n,
+		hist:    hist,
+		logger:  logger,
+		status:  st,
+		metrics: newWatchMetrics(k.Cluster()),
+	}
 }
@@ -1,7 +1,19 @@
 package kubewatch
 
 import (
-	log "github.com/inconshreveable/log15"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/luizalabs/sindico/config"
+	"github.com/luizalabs/sindico/controllers"
+	"github.com/luizalabs/sindico/history"
+	"github.com/luizalabs/sindico/notification"
+	"github.com/luizalabs/sindico/scheduler"
+	"github.com/luizalabs/sindico/status"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
 )
 
 type Controller struct {
@@ -9,16 +21,26 @@ type Controller struct {
 }
 
 type Notification interface {
-	PostMessage(msg, channel string) error
+	Send(m *notification.Message, channel string) error
+	Fire(m *notification.Message, channel string) (bool, error)
+	Resolve(meta notification.Meta, channel string) error
+	Alerts(f notification.Meta) []notification.Alert
+}
+
+type History interface {
+	Append(events ...history.Event) error
 }
 
 type K8s interface {
-	List(namespace string) ([]Pod, error)
+	Cluster() string
+	Pods() corelisters.PodLister
 	GetLabelValue(namespace, label string) (string, error)
+	Eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{})
 }
 
 type KubeWatchConfig struct {
-	CircleTime          int    `split_words:"true" default:"5"`
+	Schedule            string `split_words:"true" default:"@every 5m"`
+	CircleTime          int    `split_words:"true"`
 	K8sEnv              string `split_words:"true" default:"production"`
 	NotReadyThreshold   int    `split_words:"true" default:"60"`
 	IgnoreNsRegexp      string `split_words:"true" default:"default"`
@@ -26,7 +48,36 @@ type KubeWatchConfig struct {
 	NotificationChannel string `split_words:"true" default:"#alerts"`
 }
 
-func NewController(k8s K8s, nt Notification) *Controller {
-	logger := log.New("controller", "kubewatch")
-	return &Controller{KubeWatch: New(k8s, nt, logger)}
+// schedule keeps the deprecated CircleTime setting working.
+func (c *KubeWatchConfig) schedule() string {
+	if c.CircleTime > 0 {
+		return scheduler.Every(time.Duration(c.CircleTime) * time.Minute)
+	}
+	return c.Schedule
+}
+
+func (c *KubeWatchConfig) Validate() error {
+	if c.CircleTime < 0 {
+		return fmt.Errorf("circle time must be positive, got %d", c.CircleTime)
+	}
+	if _, _, err := scheduler.Parse(c.schedule()); err != nil {
+		return err
+	}
+	if c.NotReadyThreshold < 0 || c.NotReadyThreshold > 100 {
+		return fmt.Errorf("not ready threshold must be between 0 and 100, got %d", c.NotReadyThreshold)
+	}
+	_, err := regexp.Compile(c.IgnoreNsRegexp)
+	return err
+}
+
+func init() {
+	config.Register("sindico_kube_watch", &KubeWatchConfig{})
+	controllers.Register("kubewatch", func(deps *controllers.Deps) (controllers.Controller, error) {
+		return NewController(deps.K8s, deps.Notification, deps.History), nil
+	})
+}
+
+func NewController(k8s K8s, nt Notification, hist History) *Controller {
+	logger := controllers.Logger(k8s.Cluster(), "controller", "kubewatch")
+	return &Controller{KubeWatch: New(k8s, nt, hist, logger, status.For(k8s.Cluster(), "kubewatch"))}
 }
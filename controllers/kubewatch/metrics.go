@@ -0,0 +1,30 @@
+package kubewatch
+
+import (
+	"github.com/luizalabs/sindico/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type watchMetrics struct {
+	crashed  *prometheus.GaugeVec
+	notReady *prometheus.GaugeVec
+}
+
+func newWatchMetrics(cluster string) *watchMetrics {
+	return &watchMetrics{
+		crashed: metrics.Register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   metrics.Namespace,
+			Subsystem:   "kubewatch",
+			Name:        "crashed_pods",
+			Help:        "Number of containers in CrashLoopBackOff by namespace.",
+			ConstLabels: metrics.ClusterLabels(cluster),
+		}, []string{"namespace"})).(*prometheus.GaugeVec),
+		notReady: metrics.Register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   metrics.Namespace,
+			Subsystem:   "kubewatch",
+			Name:        "not_ready_pods",
+			Help:        "Number of running but not ready containers by namespace.",
+			ConstLabels: metrics.ClusterLabels(cluster),
+		}, []string{"namespace"})).(*prometheus.GaugeVec),
+	}
+}
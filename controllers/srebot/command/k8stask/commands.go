@@ -1,20 +1,34 @@
 package k8stask
 
 import (
+	"bytes"
 	"fmt"
 	"strconv"
 
 	"github.com/go-chat-bot/bot"
+	"github.com/luizalabs/sindico/audit"
 	"github.com/luizalabs/sindico/controllers/srebot/command"
 )
 
+const (
+	auditDays    = 7
+	auditRecords = 20
+)
+
+type Audit interface {
+	Query(days int, f *audit.Filter) ([]audit.Record, error)
+}
+
 type K8s interface {
-	DeletePod(namespace, pod string) error
-	SetReplicas(namespace, deploy string, replicas int32) error
+	Cluster() string
+	DeletePod(user, namespace, pod string) error
+	SetReplicas(user, namespace, deploy string, replicas int32) error
+	DryRun() bool
 }
 
 type Tasks struct {
 	k8s       K8s
+	audit     Audit
 	admins    map[string]bool
 	cmdPrefix string
 }
@@ -24,10 +38,10 @@ func (t *Tasks) deletePodCmd(command *bot.Cmd) (string, error) {
 		return "Invalid command usage", nil
 	}
 	ns, pod := command.Args[0], command.Args[1]
-	if err := t.k8s.DeletePod(ns, pod); err != nil {
+	if err := t.k8s.DeletePod(command.User.Nick, ns, pod); err != nil {
 		return "", err
 	}
-	return "Deleted :gun:", nil
+	return t.reply("Deleted :gun:"), nil
 }
 
 func (t *Tasks) setReplicas(command *bot.Cmd) (string, error) {
@@ -39,10 +53,42 @@ func (t *Tasks) setReplicas(command *bot.Cmd) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	if err := t.k8s.SetReplicas(ns, deploy, int32(replicas)); err != nil {
+	if err := t.k8s.SetReplicas(command.User.Nick, ns, deploy, int32(replicas)); err != nil {
+		return "", err
+	}
+	return t.reply(fmt.Sprintf("Replicas of %s set to %s :top:", deploy, sReplicas)), nil
+}
+
+func (t *Tasks) auditCmd(command *bot.Cmd) (string, error) {
+	if len(command.Args) < 1 {
+		return "Invalid command usage", nil
+	}
+	f := &audit.Filter{Cluster: t.k8s.Cluster(), Namespace: command.Args[0]}
+	if len(command.Args) > 1 {
+		f.Name = command.Args[1]
+	}
+	records, err := t.audit.Query(auditDays, f)
+	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("Replicas of %s set to %s :top:", deploy, sReplicas), nil
+	if len(records) == 0 {
+		return fmt.Sprintf("No changes in the last %d days", auditDays), nil
+	}
+	if len(records) > auditRecords {
+		records = records[len(records)-auditRecords:]
+	}
+	b := new(bytes.Buffer)
+	for _, r := range records {
+		fmt.Fprintln(b, r)
+	}
+	return b.String(), nil
+}
+
+func (t *Tasks) reply(msg string) string {
+	if t.k8s.DryRun() {
+		return fmt.Sprintf("(dry run) %s", msg)
+	}
+	return msg
 }
 
 func (t *Tasks) RegisterCommands() {
@@ -58,12 +104,19 @@ func (t *Tasks) RegisterCommands() {
 		"enter here the namespace, the name of deploy and desired number of replicas",
 		command.AdminCmd(t.admins, t.setReplicas),
 	)
+	bot.RegisterCommand(
+		fmt.Sprintf("%s-audit", t.cmdPrefix),
+		"Show the last changes made by sindico",
+		"enter here the namespace and optionally the name of the object",
+		command.Instrumented(t.auditCmd),
+	)
 }
 
-func New(k8s K8s, cmdPrefix string, admins map[string]bool) *Tasks {
+func New(k8s K8s, aud Audit, cmdPrefix string, admins map[string]bool) *Tasks {
 	return &Tasks{
 		admins:    admins,
 		k8s:       k8s,
+		audit:     aud,
 		cmdPrefix: cmdPrefix,
 	}
 }
@@ -0,0 +1,21 @@
+package command
+
+import (
+	"github.com/go-chat-bot/bot"
+	"github.com/luizalabs/sindico/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var invocations = metrics.Register(prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "srebot",
+	Name:      "command_invocations_total",
+	Help:      "Number of srebot commands invoked by command and user.",
+}, []string{"command", "user"})).(*prometheus.CounterVec)
+
+func Instrumented(fn func(*bot.Cmd) (string, error)) func(*bot.Cmd) (string, error) {
+	return func(command *bot.Cmd) (string, error) {
+		invocations.WithLabelValues(command.Command, command.User.Nick).Inc()
+		return fn(command)
+	}
+}
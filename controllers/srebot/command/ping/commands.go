@@ -1,11 +1,14 @@
 package ping
 
-import "github.com/go-chat-bot/bot"
+import (
+	"github.com/go-chat-bot/bot"
+	"github.com/luizalabs/sindico/controllers/srebot/command"
+)
 
 func ping(_ *bot.Cmd) (string, error) {
 	return "pong :table_tennis_paddle_and_ball:", nil
 }
 
 func init() {
-	bot.RegisterCommand("sre-ping", "Simple ping-pong check", "", ping)
+	bot.RegisterCommand("sre-ping", "Simple ping-pong check", "", command.Instrumented(ping))
 }
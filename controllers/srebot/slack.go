@@ -0,0 +1,154 @@
+// The slack loop below is adapted from the slack protocol handler of
+// github.com/go-chat-bot/bot (bot/slack/slack.go), used under its license:
+//
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 Fábio Gomes
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package srebot
+
+import (
+	"fmt"
+
+	"github.com/go-chat-bot/bot"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+)
+
+// slackBot connects the chat bot commands to the slack RTM api, like the
+// go-chat-bot slack handler, but stops when asked to and gives up on
+// invalid credentials instead of hanging.
+type slackBot struct {
+	c        *Controller
+	api      *slack.Client
+	rtm      *slack.RTM
+	domain   string
+	userID   string
+	channels map[string]slack.Channel
+}
+
+// runSlack blocks until stopCh is closed or the credentials turn out to be
+// invalid. It runs on the controller goroutine, so a panicking command is
+// recovered and restarted by the manager like any other controller failure.
+func (c *Controller) runSlack(token string, stopCh <-chan struct{}) error {
+	api := slack.New(token)
+	s := &slackBot{c: c, api: api, rtm: api.NewRTM(), channels: make(map[string]slack.Channel)}
+	if team, err := api.GetTeamInfo(); err == nil {
+		s.domain = team.Domain
+	}
+	c.mu.Lock()
+	c.slack = s
+	c.mu.Unlock()
+	b := c.bot
+	go s.rtm.ManageConnection()
+	defer s.rtm.Disconnect()
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case msg := <-s.rtm.IncomingEvents:
+			switch ev := msg.Data.(type) {
+			case *slack.HelloEvent:
+				s.readBotInfo()
+				s.readChannels()
+			case *slack.ChannelCreatedEvent, *slack.ChannelRenameEvent:
+				s.readChannels()
+			case *slack.MessageEvent:
+				if !ev.Hidden && ev.User != s.userID {
+					s.received(b, ev)
+				}
+			case *slack.RTMError:
+				c.logger.Error("slack rtm error", "err", ev.Error())
+			case *slack.InvalidAuthEvent:
+				return errors.New("invalid slack credentials")
+			}
+		}
+	}
+}
+
+// respond sends the responses of the bot through the current connection.
+func (c *Controller) respond(target, message string, sender *bot.User) {
+	c.mu.Lock()
+	s := c.slack
+	c.mu.Unlock()
+	if s == nil {
+		c.logger.Warn("slack not connected, response dropped", "channel", target)
+		return
+	}
+	s.respond(target, message, sender)
+}
+
+func (s *slackBot) respond(target, message string, sender *bot.User) {
+	if _, _, err := s.api.PostMessage(target, message, slack.PostMessageParameters{AsUser: true}); err != nil {
+		s.c.logger.Error("failed to post slack message", "channel", target, "err", err)
+	}
+}
+
+func (s *slackBot) received(b *bot.Bot, ev *slack.MessageEvent) {
+	ch := s.channels[ev.Channel]
+	channel := ev.Channel
+	if ch.IsChannel {
+		channel = fmt.Sprintf("#%s", ch.Name)
+	}
+	text := ev.Text
+	if text == "" && len(ev.Attachments) > 0 {
+		text = ev.Attachments[0].Fallback
+	}
+	b.MessageReceived(&bot.ChannelData{
+		Protocol:  "slack",
+		Server:    s.domain,
+		Channel:   channel,
+		IsPrivate: !ch.IsChannel,
+	}, text, s.user(ev))
+}
+
+func (s *slackBot) user(ev *slack.MessageEvent) *bot.User {
+	id, isBot := ev.User, false
+	if id == "" {
+		id, isBot = ev.BotID, true
+	}
+	u, err := s.api.GetUserInfo(id)
+	if err != nil {
+		s.c.logger.Error("failed to get slack user", "user", id, "err", err)
+		return &bot.User{ID: id, IsBot: isBot}
+	}
+	return &bot.User{ID: id, Nick: u.Name, RealName: u.Profile.RealName, IsBot: isBot}
+}
+
+func (s *slackBot) readBotInfo() {
+	info, err := s.api.AuthTest()
+	if err != nil {
+		s.c.logger.Error("failed to get slack bot info", "err", err)
+		return
+	}
+	s.userID = info.UserID
+}
+
+func (s *slackBot) readChannels() {
+	channels, err := s.api.GetChannels(true)
+	if err != nil {
+		s.c.logger.Error("failed to get slack channels", "err", err)
+		return
+	}
+	for _, ch := range channels {
+		s.channels[ch.ID] = ch
+	}
+}
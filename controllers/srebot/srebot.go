@@ -2,23 +2,36 @@ package srebot
 
 import (
 	"strings"
+	"sync"
 
-	"github.com/go-chat-bot/bot/slack"
+	"github.com/go-chat-bot/bot"
 	log "github.com/inconshreveable/log15"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/luizalabs/sindico/config"
+	"github.com/luizalabs/sindico/controllers"
 	"github.com/luizalabs/sindico/controllers/srebot/command/k8stask"
 	"github.com/luizalabs/sindico/controllers/srebot/command/keeptrack"
 	_ "github.com/luizalabs/sindico/controllers/srebot/command/ping"
+	"github.com/luizalabs/sindico/status"
 )
 
 type K8s interface {
-	DeletePod(namespace, pod string) error
-	SetReplicas(namespace, deploy string, replicas int32) error
+	Cluster() string
+	DeletePod(user, namespace, pod string) error
+	SetReplicas(user, namespace, deploy string, replicas int32) error
+	DryRun() bool
 }
 
 type Controller struct {
 	k8s    K8s
+	audit  k8stask.Audit
 	logger log.Logger
+	status *status.Component
+	// the commands go to the global registry of the chat bot, so they and
+	// the bot are set up once, not on every restart
+	setup sync.Once
+	bot   *bot.Bot
+	mu    sync.Mutex
+	slack *slackBot
 }
 
 type SreBotConfig struct {
@@ -27,11 +40,20 @@ type SreBotConfig struct {
 	CmdPrefix  string `split_words:"true" default:"production"`
 }
 
+func init() {
+	config.Register("sindico_sre_bot", &SreBotConfig{})
+	// the slack bot and its commands are global, so it serves a single cluster
+	controllers.RegisterPrimary("srebot", func(deps *controllers.Deps) (controllers.Controller, error) {
+		return NewController(deps.K8s, deps.Audit), nil
+	})
+}
+
 func (c *Controller) Run(stopCh <-chan struct{}) {
 	defer c.logger.Debug("stopped")
 	var cfg SreBotConfig
-	if err := envconfig.Process("sindico_sre_bot", &cfg); err != nil {
-		log.Error("failed to process env vars", "err", err)
+	if err := config.Process("sindico_sre_bot", &cfg); err != nil {
+		c.logger.Error("failed to process config", "err", err)
+		c.status.Fail(err)
 		return
 	}
 	c.logger.Debug("starting")
@@ -39,12 +61,22 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 	for _, a := range strings.Split(cfg.Admins, ",") {
 		admins[a] = true
 	}
-	keeptrack.New(admins).RegisterCommands()
-	k8stask.New(c.k8s, cfg.CmdPrefix, admins).RegisterCommands()
-	slack.Run(cfg.SlackToken)
+	c.setup.Do(func() {
+		keeptrack.New(admins).RegisterCommands()
+		k8stask.New(c.k8s, c.audit, cfg.CmdPrefix, admins).RegisterCommands()
+		c.bot = bot.New(&bot.Handlers{Response: c.respond})
+		c.bot.Disable([]string{"url"})
+	})
+	if err := c.runSlack(cfg.SlackToken, stopCh); err != nil {
+		// restarting won't fix the token, and a crash looping srebot would
+		// fail the liveness probe of every other controller
+		c.logger.Error("srebot disabled until sindico restarts", "err", err)
+		c.status.Fail(err)
+		<-stopCh
+	}
 }
 
-func NewController(k8s K8s) *Controller {
-	logger := log.New("controller", "srebot")
-	return &Controller{k8s: k8s, logger: logger}
+func NewController(k8s K8s, aud k8stask.Audit) *Controller {
+	logger := controllers.Logger(k8s.Cluster(), "controller", "srebot")
+	return &Controller{k8s: k8s, audit: aud, logger: logger, status: status.For(k8s.Cluster(), "srebot")}
 }
@@ -1,69 +1,119 @@
 package watchdog
 
 import (
+	"fmt"
 	"regexp"
 	"time"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/luizalabs/sindico/config"
+	"github.com/luizalabs/sindico/k8s"
+	"github.com/luizalabs/sindico/scheduler"
+	"github.com/luizalabs/sindico/status"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 
 	asv1 "k8s.io/api/autoscaling/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	aslisters "k8s.io/client-go/listers/autoscaling/v1"
 )
 
 type HPASubController struct {
 	logger log.Logger
 	k8s    K8s
+	hpas   aslisters.HorizontalPodAutoscalerLister
+	status *status.Component
+	clamps *prometheus.CounterVec
 }
 
 type HPASubControllerConfig struct {
-	Interval       time.Duration `split_words:"true" default:"5m"`
+	Schedule       string        `split_words:"true" default:"@every 5m"`
+	Interval       time.Duration `split_words:"true"`
 	IgnoreNsRegexp string        `split_words:"true" default:"nginx-.+|sindico|default|kube-.+"`
 	MinReplicas    int32         `split_words:"true" default:"2"`
 	MaxReplicas    int32         `split_words:"true" default:"2"`
 }
 
-func (h *HPASubController) Run(stopCh <-chan struct{}) {
-	var cfg HPASubControllerConfig
-	if err := envconfig.Process("sindico_watchdog_hpa", &cfg); err != nil {
-		h.logger.Error("failed to process env vars", "err", err)
-		return
+func (c *HPASubControllerConfig) Validate() error {
+	if err := validate(c.Schedule, c.Interval, c.IgnoreNsRegexp); err != nil {
+		return err
 	}
-	re, err := regexp.Compile(cfg.IgnoreNsRegexp)
+	if c.MinReplicas < 1 || c.MaxReplicas < c.MinReplicas {
+		return fmt.Errorf("invalid replicas range %d-%d", c.MinReplicas, c.MaxReplicas)
+	}
+	return nil
+}
+
+func (h *HPASubController) Run(stopCh <-chan struct{}) {
+	cfg, re, err := loadHPAConfig()
 	if err != nil {
-		h.logger.Error("invalid ignore regex", "err", err)
+		h.logger.Error("invalid config", "err", err)
+		h.status.Fail(err)
 		return
 	}
 	h.logger.Debug("starting")
-	fn := func() { h.run(re, &cfg) }
-	go wait.JitterUntil(fn, cfg.Interval, 0.1, true, stopCh)
-	<-stopCh
+	fn := func() {
+		if newCfg, newRe, err := loadHPAConfig(); err != nil {
+			h.logger.Error("failed to reload config", "err", err)
+		} else {
+			cfg, re = newCfg, newRe
+		}
+		h.status.Run(func() error { return h.run(re, cfg) })
+	}
+	spec := func() string { return schedule(cfg.Schedule, cfg.Interval) }
+	if err := scheduler.Run(h.status, spec, fn, stopCh); err != nil {
+		h.logger.Error("invalid schedule", "err", err)
+		h.status.Fail(err)
+		return
+	}
 	h.logger.Debug("stopped")
 }
 
-func (h *HPASubController) run(re *regexp.Regexp, cfg *HPASubControllerConfig) {
-	cli, err := h.k8s.NewClientset()
+// RunOnce does a single check right away.
+func (h *HPASubController) RunOnce() error {
+	cfg, re, err := loadHPAConfig()
 	if err != nil {
-		h.logger.Error("failed to build clientset", "err", err)
-		return
+		return err
 	}
-	hpas, err := cli.AutoscalingV1().HorizontalPodAutoscalers(metav1.NamespaceAll).List(metav1.ListOptions{})
+	return h.run(re, cfg)
+}
+
+func loadHPAConfig() (*HPASubControllerConfig, *regexp.Regexp, error) {
+	var cfg HPASubControllerConfig
+	if err := config.Process("sindico_watchdog_hpa", &cfg); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to process config")
+	}
+	re, err := regexp.Compile(cfg.IgnoreNsRegexp)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid ignore regex")
+	}
+	return &cfg, re, nil
+}
+
+func (h *HPASubController) run(re *regexp.Regexp, cfg *HPASubControllerConfig) error {
+	hpas, err := h.hpas.List(labels.Everything())
 	if err != nil {
 		h.logger.Error("hpa list failed", "err", err)
-		return
+		return errors.Wrap(err, "hpa list failed")
 	}
-	for _, hpa := range hpas.Items {
+	var errs []error
+	for _, hpa := range hpas {
 		ns := hpa.ObjectMeta.Namespace
 		if re != nil && re.MatchString(ns) {
 			h.logger.Debug("skip ns regex", "ns", ns)
 			continue
 		}
-		h.updateHPA(cfg, &hpa)
+		if err := h.updateHPA(cfg, hpa); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	return utilerrors.NewAggregate(errs)
 }
 
-func (h *HPASubController) updateHPA(cfg *HPASubControllerConfig, hpa *asv1.HorizontalPodAutoscaler) {
+func (h *HPASubController) updateHPA(cfg *HPASubControllerConfig, old *asv1.HorizontalPodAutoscaler) error {
+	hpa := old.DeepCopy()
 	oldSpec := hpa.Spec
 	min := cfg.MinReplicas
 	ns := hpa.ObjectMeta.Namespace
@@ -77,18 +127,26 @@ func (h *HPASubController) updateHPA(cfg *HPASubControllerConfig, hpa *asv1.Hori
 	}
 	if hpa.Spec == oldSpec {
 		h.logger.Debug("skipped update", "ns", ns)
-	} else {
-		cli, err := h.k8s.NewClientset()
-		if err != nil {
-			h.logger.Error("failed to build client set", "err", err)
-			return
-		}
-		if _, err := cli.AutoscalingV1().HorizontalPodAutoscalers(ns).Update(hpa); err != nil {
-			h.logger.Error("failed to update hpa", "err", err)
-		}
+		return nil
 	}
+	if err := h.k8s.UpdateHorizontalPodAutoscaler(old, hpa); err != nil {
+		h.logger.Error("failed to update hpa", "err", err)
+		return errors.Wrapf(err, "failed to update hpa %s/%s", ns, hpa.Name)
+	}
+	if !h.k8s.DryRun() {
+		h.clamps.WithLabelValues(ns).Inc()
+	}
+	h.k8s.Eventf(hpa, k8sv1.EventTypeWarning, k8s.ReasonHPAClamped,
+		"Replicas limited by sindico to %d min and %d max", cfg.MinReplicas, cfg.MaxReplicas)
+	return nil
 }
 
 func newHPASubController(k8s K8s, logger log.Logger) *HPASubController {
-	return &HPASubController{k8s: k8s, logger: logger}
+	return &HPASubController{
+		k8s:    k8s,
+		hpas:   k8s.HorizontalPodAutoscalers(),
+		logger: logger,
+		status: status.For(k8s.Cluster(), "watchdog/hpa"),
+		clamps: newClampsMetric(k8s.Cluster(), "hpa_clamps_total", "Number of HPAs clamped by namespace."),
+	}
 }
@@ -5,70 +5,121 @@ import (
 	"time"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/luizalabs/sindico/config"
+	"github.com/luizalabs/sindico/k8s"
+	"github.com/luizalabs/sindico/scheduler"
+	"github.com/luizalabs/sindico/status"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 
 	k8sv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
 )
 
 type LimitsSubController struct {
 	k8s    K8s
+	lims   corelisters.LimitRangeLister
 	logger log.Logger
+	status *status.Component
+	clamps *prometheus.CounterVec
 }
 
 type LimitsSubControllerConfig struct {
-	Interval       time.Duration `split_words:"true" default:"5m"`
+	Schedule       string        `split_words:"true" default:"@every 5m"`
+	Interval       time.Duration `split_words:"true"`
 	IgnoreNsRegexp string        `split_words:"true" default:"nginx-.+|sindico|default|kube-.+"`
 	RequestCPU     string        `split_words:"true" default:"100m"`
 	RequestMemory  string        `split_words:"true" default:"512Mi"`
 }
 
-func (l *LimitsSubController) Run(stopCh <-chan struct{}) {
-	var cfg LimitsSubControllerConfig
-	if err := envconfig.Process("sindico_watchdog_limits", &cfg); err != nil {
-		l.logger.Error("failed to process env vars", "err", err)
-		return
+func (c *LimitsSubControllerConfig) Validate() error {
+	if err := validate(c.Schedule, c.Interval, c.IgnoreNsRegexp); err != nil {
+		return err
 	}
-	re, err := regexp.Compile(cfg.IgnoreNsRegexp)
+	if _, err := resource.ParseQuantity(c.RequestCPU); err != nil {
+		return errors.Wrap(err, "invalid request cpu")
+	}
+	if _, err := resource.ParseQuantity(c.RequestMemory); err != nil {
+		return errors.Wrap(err, "invalid request memory")
+	}
+	return nil
+}
+
+func (l *LimitsSubController) Run(stopCh <-chan struct{}) {
+	cfg, re, err := loadLimitsConfig()
 	if err != nil {
-		l.logger.Error("invalid ignore regex", "err", err)
+		l.logger.Error("invalid config", "err", err)
+		l.status.Fail(err)
 		return
 	}
 	l.logger.Debug("starting")
-	fn := func() { l.run(re, &cfg) }
-	go wait.JitterUntil(fn, cfg.Interval, 0.1, true, stopCh)
-	<-stopCh
+	fn := func() {
+		if newCfg, newRe, err := loadLimitsConfig(); err != nil {
+			l.logger.Error("failed to reload config", "err", err)
+		} else {
+			cfg, re = newCfg, newRe
+		}
+		l.status.Run(func() error { return l.run(re, cfg) })
+	}
+	spec := func() string { return schedule(cfg.Schedule, cfg.Interval) }
+	if err := scheduler.Run(l.status, spec, fn, stopCh); err != nil {
+		l.logger.Error("invalid schedule", "err", err)
+		l.status.Fail(err)
+		return
+	}
 	l.logger.Debug("stopped")
 }
 
-func (l *LimitsSubController) run(re *regexp.Regexp, cfg *LimitsSubControllerConfig) {
-	cli, err := l.k8s.NewClientset()
+// RunOnce does a single check right away.
+func (l *LimitsSubController) RunOnce() error {
+	cfg, re, err := loadLimitsConfig()
 	if err != nil {
-		l.logger.Error("failed to build clientset", "err", err)
-		return
+		return err
 	}
-	lims, err := cli.CoreV1().LimitRanges(metav1.NamespaceAll).List(metav1.ListOptions{})
+	return l.run(re, cfg)
+}
+
+func loadLimitsConfig() (*LimitsSubControllerConfig, *regexp.Regexp, error) {
+	var cfg LimitsSubControllerConfig
+	if err := config.Process("sindico_watchdog_limits", &cfg); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to process config")
+	}
+	re, err := regexp.Compile(cfg.IgnoreNsRegexp)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid ignore regex")
+	}
+	return &cfg, re, nil
+}
+
+func (l *LimitsSubController) run(re *regexp.Regexp, cfg *LimitsSubControllerConfig) error {
+	lims, err := l.lims.List(labels.Everything())
 	if err != nil {
 		l.logger.Error("limits list failed", "err", err)
-		return
+		return errors.Wrap(err, "limits list failed")
 	}
-	for _, lim := range lims.Items {
+	var errs []error
+	for _, lim := range lims {
 		ns := lim.ObjectMeta.Namespace
 		if re != nil && re.MatchString(ns) {
 			l.logger.Debug("skip ns regex", "ns", ns)
 			continue
 		}
-		l.updateLimits(cfg, &lim)
+		if err := l.updateLimits(cfg, lim); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	return utilerrors.NewAggregate(errs)
 }
 
-func (l *LimitsSubController) updateLimits(cfg *LimitsSubControllerConfig, lim *k8sv1.LimitRange) {
+func (l *LimitsSubController) updateLimits(cfg *LimitsSubControllerConfig, old *k8sv1.LimitRange) error {
+	lim := old.DeepCopy()
 	ns := lim.ObjectMeta.Namespace
 	if len(lim.Spec.Limits) == 0 {
 		l.logger.Debug("request not found", "ns", ns)
-		return
+		return nil
 	}
 	defReq := lim.Spec.Limits[0].DefaultRequest
 	cpu := defReq.Cpu()
@@ -86,19 +137,28 @@ func (l *LimitsSubController) updateLimits(cfg *LimitsSubControllerConfig, lim *
 		defReq["memory"] = cfgMem
 		update = true
 	}
-	if update {
-		cli, err := l.k8s.NewClientset()
-		if err != nil {
-			l.logger.Error("failed to build clientset", "ns", ns, "err", err)
-		}
-		if _, err := cli.CoreV1().LimitRanges(ns).Update(lim); err != nil {
-			l.logger.Error("failed to update limits", "ns", ns, "err", err)
-		}
-	} else {
+	if !update {
 		l.logger.Debug("skipped update", "ns", ns)
+		return nil
 	}
+	if err := l.k8s.UpdateLimitRange(old, lim); err != nil {
+		l.logger.Error("failed to update limits", "ns", ns, "err", err)
+		return errors.Wrapf(err, "failed to update limits %s/%s", ns, lim.Name)
+	}
+	if !l.k8s.DryRun() {
+		l.clamps.WithLabelValues(ns).Inc()
+	}
+	l.k8s.Eventf(lim, k8sv1.EventTypeWarning, k8s.ReasonLimitRangeClamped,
+		"Default requests limited by sindico to %s cpu and %s memory", cfg.RequestCPU, cfg.RequestMemory)
+	return nil
 }
 
 func newLimitsSubController(k8s K8s, logger log.Logger) *LimitsSubController {
-	return &LimitsSubController{k8s: k8s, logger: logger}
+	return &LimitsSubController{
+		k8s:    k8s,
+		lims:   k8s.LimitRanges(),
+		logger: logger,
+		status: status.For(k8s.Cluster(), "watchdog/limits"),
+		clamps: newClampsMetric(k8s.Cluster(), "limits_clamps_total", "Number of LimitRanges clamped by namespace."),
+	}
 }
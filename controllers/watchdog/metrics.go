@@ -0,0 +1,26 @@
+package watchdog
+
+import (
+	"github.com/luizalabs/sindico/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newClampsMetric(cluster, name, help string) *prometheus.CounterVec {
+	return metrics.Register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   metrics.Namespace,
+		Subsystem:   "watchdog",
+		Name:        name,
+		Help:        help,
+		ConstLabels: metrics.ClusterLabels(cluster),
+	}, []string{"namespace"})).(*prometheus.CounterVec)
+}
+
+func newServicesWithoutFirewallMetric(cluster string) *prometheus.GaugeVec {
+	return metrics.Register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   metrics.Namespace,
+		Subsystem:   "watchdog",
+		Name:        "services_without_firewall",
+		Help:        "Number of LoadBalancer services without source ranges by namespace.",
+		ConstLabels: metrics.ClusterLabels(cluster),
+	}, []string{"namespace"})).(*prometheus.GaugeVec)
+}
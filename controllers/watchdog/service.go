@@ -1,103 +1,217 @@
 package watchdog
 
 import (
-	"fmt"
 	"regexp"
 	"time"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/luizalabs/sindico/config"
+	"github.com/luizalabs/sindico/k8s"
+	"github.com/luizalabs/sindico/notification"
+	"github.com/luizalabs/sindico/scheduler"
+	"github.com/luizalabs/sindico/status"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 
 	k8sv1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
 )
 
 type Notification interface {
-	PostMessage(msg, channel string) error
+	Send(m *notification.Message, channel string) error
+	Fire(m *notification.Message, channel string) (bool, error)
+	Resolve(meta notification.Meta, channel string) error
+	Clear(meta notification.Meta)
+	Alerts(f notification.Meta) []notification.Alert
 }
 
 type ServiceSubController struct {
 	logger log.Logger
 	k8s    K8s
+	svcs   corelisters.ServiceLister
 	nt     Notification
+	status *status.Component
+	noFw   *prometheus.GaugeVec
 }
 
 type ServiceSubControllerConfig struct {
-	Interval                  time.Duration `split_words:"true" default:"5m"`
+	Schedule                  string        `split_words:"true" default:"@every 5m"`
+	Interval                  time.Duration `split_words:"true"`
 	CheckFirewall             bool          `split_words:"true" default:"false"`
 	CheckFirewallSkipNsRegexp string        `split_words:"true" default:"nginx-.+|sindico|default|kube-.+"`
 	NotificationChannel       string        `split_words:"true" default:"#alerts"`
 	TeamNsLabel               string        `split_words:"true" default:"teresa.io/team"`
 }
 
+func (c *ServiceSubControllerConfig) Validate() error {
+	return validate(c.Schedule, c.Interval, c.CheckFirewallSkipNsRegexp)
+}
+
 func (s *ServiceSubController) Run(stopCh <-chan struct{}) {
-	var cfg ServiceSubControllerConfig
-	if err := envconfig.Process("sindico_watchdog_service", &cfg); err != nil {
-		s.logger.Error("failed to process env vars", "err", err)
-		return
-	}
-	re, err := regexp.Compile(cfg.CheckFirewallSkipNsRegexp)
+	cfg, re, err := loadServiceConfig()
 	if err != nil {
-		s.logger.Error("invalid check firewall skip regex", "err", err)
+		s.logger.Error("invalid config", "err", err)
+		s.status.Fail(err)
 		return
 	}
 	s.logger.Debug("starting")
-	fn := func() { s.checkFirewall(re, &cfg) }
-	go wait.JitterUntil(fn, cfg.Interval, 0.1, true, stopCh)
-	<-stopCh
+	fn := func() {
+		if newCfg, newRe, err := loadServiceConfig(); err != nil {
+			s.logger.Error("failed to reload config", "err", err)
+		} else {
+			cfg, re = newCfg, newRe
+		}
+		s.status.Run(func() error { return s.checkFirewall(re, cfg) })
+	}
+	spec := func() string { return schedule(cfg.Schedule, cfg.Interval) }
+	if err := scheduler.Run(s.status, spec, fn, stopCh); err != nil {
+		s.logger.Error("invalid schedule", "err", err)
+		s.status.Fail(err)
+		return
+	}
 	s.logger.Debug("stopped")
 }
 
-func (s *ServiceSubController) checkFirewall(re *regexp.Regexp, cfg *ServiceSubControllerConfig) {
-	if !cfg.CheckFirewall {
-		s.logger.Debug("firewall check disabled")
-		return
+// RunOnce does a single check right away. A dry-run report lists the
+// services without firewall even if the check is disabled.
+func (s *ServiceSubController) RunOnce() error {
+	cfg, re, err := loadServiceConfig()
+	if err != nil {
+		return err
+	}
+	if s.k8s.DryRun() {
+		cfg.CheckFirewall = true
 	}
-	cli, err := s.k8s.NewClientset()
+	return s.checkFirewall(re, cfg)
+}
+
+func loadServiceConfig() (*ServiceSubControllerConfig, *regexp.Regexp, error) {
+	var cfg ServiceSubControllerConfig
+	if err := config.Process("sindico_watchdog_service", &cfg); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to process config")
+	}
+	re, err := regexp.Compile(cfg.CheckFirewallSkipNsRegexp)
 	if err != nil {
-		s.logger.Error("failed to build clientset", "err", err)
-		return
+		return nil, nil, errors.Wrap(err, "invalid check firewall skip regex")
 	}
-	svcs, err := cli.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{})
+	return &cfg, re, nil
+}
+
+func (s *ServiceSubController) checkFirewall(re *regexp.Regexp, cfg *ServiceSubControllerConfig) error {
+	if !cfg.CheckFirewall {
+		// the alerts of before the check was disabled would never clear,
+		// they're dropped silently as the services may still lack rules
+		s.logger.Debug("firewall check disabled")
+		s.noFw.Reset()
+		for _, a := range s.nt.Alerts(notification.Meta{Condition: "NoFirewall"}) {
+			s.nt.Clear(a.Meta)
+		}
+		return nil
+	}
+	svcs, err := s.svcs.List(labels.Everything())
 	if err != nil {
 		s.logger.Error("services list failed", "err", err)
-		return
+		return errors.Wrap(err, "services list failed")
 	}
-	for _, svc := range svcs.Items {
+	s.noFw.Reset()
+	var errs []error
+	firing := make(map[string]bool)
+	for _, svc := range svcs {
 		ns := svc.ObjectMeta.Namespace
 		if re != nil && re.MatchString(ns) {
 			s.logger.Debug("skip ns regex", "ns", ns)
 			continue
 		}
-		s.checkService(cfg, &svc)
+		if withoutFirewall(svc) {
+			firing[ns+"/"+svc.Name] = true
+		}
+		if err := s.checkService(cfg, svc); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := s.resolve(firing, cfg); err != nil {
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// resolve clears the NoFirewall alerts of the services not in firing.
+func (s *ServiceSubController) resolve(firing map[string]bool, cfg *ServiceSubControllerConfig) error {
+	var errs []error
+	for _, a := range s.nt.Alerts(notification.Meta{Condition: "NoFirewall"}) {
+		if firing[a.Namespace+"/"+a.Object] {
+			continue
+		}
+		s.logger.Info("alert resolved", "ns", a.Namespace, "service", a.Object)
+		if err := s.nt.Resolve(a.Meta, cfg.NotificationChannel); err != nil {
+			s.logger.Error("failed to post message", "err", err)
+			errs = append(errs, errors.Wrap(err, "failed to post message"))
+		}
 	}
+	return utilerrors.NewAggregate(errs)
 }
 
-func (s *ServiceSubController) checkService(cfg *ServiceSubControllerConfig, svc *k8sv1.Service) {
-	if len(svc.Spec.LoadBalancerSourceRanges) == 0 && svc.Spec.Type == "LoadBalancer" {
+func withoutFirewall(svc *k8sv1.Service) bool {
+	return len(svc.Spec.LoadBalancerSourceRanges) == 0 && svc.Spec.Type == "LoadBalancer"
+}
+
+func (s *ServiceSubController) checkService(cfg *ServiceSubControllerConfig, svc *k8sv1.Service) error {
+	if withoutFirewall(svc) {
 		ns := svc.Namespace
+		s.noFw.WithLabelValues(ns).Inc()
 		team, err := s.k8s.GetLabelValue(ns, cfg.TeamNsLabel)
 		if err != nil {
-			s.notify(
-				fmt.Sprintf(":bomb: failed to get namespace label: *%v*", err),
-				cfg.NotificationChannel,
-			)
-			return
+			s.notify(&notification.Message{
+				Meta:  notification.Meta{Namespace: ns, Severity: notification.SeverityWarning},
+				Title: "Failed to get namespace label",
+				Text:  err.Error(),
+			}, cfg.NotificationChannel)
+			return errors.Wrap(err, "failed to get namespace label")
+		}
+		m := &notification.Message{
+			Meta: notification.Meta{
+				Namespace: ns,
+				Team:      team,
+				Severity:  notification.SeverityWarning,
+				Object:    svc.Name,
+				Condition: "NoFirewall",
+			},
+			Title: "LoadBalancer service without firewall rules",
+		}
+		m.AddField("Namespace", ns).AddField("Service", svc.Name)
+		if team != "" {
+			m.AddField("Team", "@"+team)
+		}
+		fired, err := s.nt.Fire(m, cfg.NotificationChannel)
+		if fired {
+			s.k8s.Eventf(svc, k8sv1.EventTypeWarning, k8s.ReasonServiceWithoutFirewall,
+				"LoadBalancer service without loadBalancerSourceRanges")
+		}
+		if err != nil {
+			s.logger.Error("failed to post message", "err", err)
+			return errors.Wrap(err, "failed to post message")
 		}
-		s.notify(
-			fmt.Sprintf(":shit: (@%s) namespace *%s* without firewall rules", team, ns),
-			cfg.NotificationChannel,
-		)
 	}
+	return nil
 }
 
-func (s *ServiceSubController) notify(msg, channel string) {
-	if err := s.nt.PostMessage(msg, channel); err != nil {
+func (s *ServiceSubController) notify(m *notification.Message, channel string) error {
+	if err := s.nt.Send(m, channel); err != nil {
 		s.logger.Error("failed to post message", "err", err)
+		return errors.Wrap(err, "failed to post message")
 	}
+	return nil
 }
 
 func newServiceSubController(k8s K8s, logger log.Logger, nt Notification) *ServiceSubController {
-	return &ServiceSubController{k8s: k8s, logger: logger, nt: nt}
+	return &ServiceSubController{
+		k8s:    k8s,
+		svcs:   k8s.Services(),
+		logger: logger,
+		nt:     nt,
+		status: status.For(k8s.Cluster(), "watchdog/service"),
+		noFw:   newServicesWithoutFirewallMetric(k8s.Cluster()),
+	}
 }
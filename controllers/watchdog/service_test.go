@@ -0,0 +1,89 @@
+package watchdog
+
+import (
+	"reflect"
+	"testing"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/notification"
+	"github.com/prometheus/client_golang/prometheus"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+type fakeNotification struct {
+	alerts   []notification.Alert
+	resolved []string
+	cleared  []string
+}
+
+func (f *fakeNotification) Send(m *notification.Message, channel string) error {
+	return nil
+}
+
+func (f *fakeNotification) Fire(m *notification.Message, channel string) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeNotification) Resolve(meta notification.Meta, channel string) error {
+	f.resolved = append(f.resolved, meta.Namespace+"/"+meta.Object)
+	return nil
+}
+
+func (f *fakeNotification) Clear(meta notification.Meta) {
+	f.cleared = append(f.cleared, meta.Namespace+"/"+meta.Object)
+}
+
+func (f *fakeNotification) Alerts(meta notification.Meta) []notification.Alert {
+	return f.alerts
+}
+
+func TestCheckFirewallAlerts(t *testing.T) {
+	tests := []struct {
+		name         string
+		enabled      bool
+		wantResolved []string
+		wantCleared  []string
+	}{
+		{name: "enabled", enabled: true, wantResolved: []string{"cart/api"}},
+		{name: "disabled", enabled: false, wantCleared: []string{"cart/api"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the service got firewall rules since the alert fired
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			indexer.Add(&k8sv1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "cart", Name: "api"},
+				Spec: k8sv1.ServiceSpec{
+					Type:                     "LoadBalancer",
+					LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+				},
+			})
+			nt := &fakeNotification{alerts: []notification.Alert{
+				{Meta: notification.Meta{Namespace: "cart", Object: "api", Condition: "NoFirewall"}},
+			}}
+			logger := log.New()
+			logger.SetHandler(log.DiscardHandler())
+			s := &ServiceSubController{
+				logger: logger,
+				svcs:   corelisters.NewServiceLister(indexer),
+				nt:     nt,
+				noFw:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test"}, []string{"namespace"}),
+			}
+
+			cfg := &ServiceSubControllerConfig{CheckFirewall: tt.enabled, NotificationChannel: "#alerts"}
+			if err := s.checkFirewall(nil, cfg); err != nil {
+				t.Fatal("got error:", err)
+			}
+			if !reflect.DeepEqual(nt.resolved, tt.wantResolved) {
+				t.Errorf("got resolved %q, want %q", nt.resolved, tt.wantResolved)
+			}
+			if !reflect.DeepEqual(nt.cleared, tt.wantCleared) {
+				t.Errorf("got cleared %q, want %q", nt.cleared, tt.wantCleared)
+			}
+		})
+	}
+}
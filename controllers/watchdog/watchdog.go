@@ -1,35 +1,63 @@
 package watchdog
 
 import (
-	log "github.com/inconshreveable/log15"
+	"fmt"
+	"regexp"
+	"time"
 
-	"k8s.io/client-go/kubernetes"
+	"github.com/luizalabs/sindico/config"
+	"github.com/luizalabs/sindico/controllers"
+	"github.com/luizalabs/sindico/scheduler"
+
+	asv1 "k8s.io/api/autoscaling/v1"
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	aslisters "k8s.io/client-go/listers/autoscaling/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 )
 
 type K8s interface {
-	NewClientset() (kubernetes.Interface, error)
+	Cluster() string
+	DryRun() bool
+	UpdateHorizontalPodAutoscaler(old, updated *asv1.HorizontalPodAutoscaler) error
+	UpdateLimitRange(old, updated *k8sv1.LimitRange) error
+	HorizontalPodAutoscalers() aslisters.HorizontalPodAutoscalerLister
+	LimitRanges() corelisters.LimitRangeLister
+	Services() corelisters.ServiceLister
 	GetLabelValue(namespace, label string) (string, error)
+	Eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{})
 }
 
-type SubController interface {
-	Run(done <-chan struct{})
-}
-
-type Controller struct {
-	subCtrls []SubController
+func init() {
+	config.Register("sindico_watchdog_hpa", &HPASubControllerConfig{})
+	config.Register("sindico_watchdog_limits", &LimitsSubControllerConfig{})
+	config.Register("sindico_watchdog_service", &ServiceSubControllerConfig{})
+	controllers.Register("watchdog/hpa", func(deps *controllers.Deps) (controllers.Controller, error) {
+		return newHPASubController(deps.K8s, controllers.Logger(deps.K8s.Cluster(), "controller", "watchdog", "subcontroller", "hpa")), nil
+	})
+	controllers.Register("watchdog/limits", func(deps *controllers.Deps) (controllers.Controller, error) {
+		return newLimitsSubController(deps.K8s, controllers.Logger(deps.K8s.Cluster(), "controller", "watchdog", "subcontroller", "limits")), nil
+	})
+	controllers.Register("watchdog/service", func(deps *controllers.Deps) (controllers.Controller, error) {
+		return newServiceSubController(deps.K8s, controllers.Logger(deps.K8s.Cluster(), "controller", "watchdog", "subcontroller", "service"), deps.Notification), nil
+	})
 }
 
-func NewController(k8s K8s, nt Notification) *Controller {
-	subCtrls := []SubController{
-		newHPASubController(k8s, log.New("subcontroller", "hpa")),
-		newLimitsSubController(k8s, log.New("subcontroller", "limits")),
-		newServiceSubController(k8s, log.New("subcontroller", "service"), nt),
+// schedule keeps the deprecated Interval settings working.
+func schedule(spec string, interval time.Duration) string {
+	if interval > 0 {
+		return scheduler.Every(interval)
 	}
-	return &Controller{subCtrls: subCtrls}
+	return spec
 }
 
-func (c *Controller) Run(stopCh <-chan struct{}) {
-	for _, subCtrl := range c.subCtrls {
-		go subCtrl.Run(stopCh)
+func validate(spec string, interval time.Duration, nsRegexp string) error {
+	if interval < 0 {
+		return fmt.Errorf("interval must be positive, got %s", interval)
+	}
+	if _, _, err := scheduler.Parse(schedule(spec, interval)); err != nil {
+		return err
 	}
+	_, err := regexp.Compile(nsRegexp)
+	return err
 }
@@ -0,0 +1,92 @@
+package history
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/luizalabs/sindico/storage"
+)
+
+const (
+	// KindCrash is a period a namespace had pods in CrashLoopBackOff, from
+	// Since to Time.
+	KindCrash = "crash"
+	// KindNotReady is the average, in Value, and the maximum percentage of
+	// not ready pods of a namespace over Samples checks.
+	KindNotReady = "not_ready"
+	// KindBackup is an etcd backup of Value bytes, failed if Error is set.
+	KindBackup = "backup"
+)
+
+type Config struct {
+	Dir string `split_words:"true" default:"history"`
+}
+
+// Event is something observed by a controller, kept for the digests.
+type Event struct {
+	Time      time.Time  `json:"time"`
+	Cluster   string     `json:"cluster,omitempty"`
+	Kind      string     `json:"kind"`
+	Namespace string     `json:"namespace,omitempty"`
+	Team      string     `json:"team,omitempty"`
+	Since     *time.Time `json:"since,omitempty"`
+	Value     float64    `json:"value,omitempty"`
+	Max       float64    `json:"max,omitempty"`
+	Samples   int        `json:"samples,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Filter selects events, empty fields match anything.
+type Filter struct {
+	Cluster   string
+	Kind      string
+	Namespace string
+}
+
+func (f *Filter) match(e *Event) bool {
+	return (f.Cluster == "" || f.Cluster == e.Cluster) &&
+		(f.Kind == "" || f.Kind == e.Kind) &&
+		(f.Namespace == "" || f.Namespace == e.Namespace)
+}
+
+// Log keeps the events as JSON lines in a storage.Daily, e.g. under
+// history/2018-08-01/.
+type Log struct {
+	daily *storage.Daily
+}
+
+func New(st storage.ReadWriter, cfg *Config) *Log {
+	return &Log{daily: storage.NewDaily(st, cfg.Dir)}
+}
+
+// Append adds events to the file of the day of the first one. A nil Log
+// drops the events.
+func (l *Log) Append(events ...Event) error {
+	if l == nil || len(events) == 0 {
+		return nil
+	}
+	es := make([]interface{}, len(events))
+	for i := range events {
+		es[i] = events[i]
+	}
+	return l.daily.Append(events[0].Time, es...)
+}
+
+// Query returns the events of the last days matching f, oldest first.
+func (l *Log) Query(days int, f *Filter) ([]Event, error) {
+	events := []Event{}
+	err := l.daily.Scan(days, func(line []byte) error {
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			return err
+		}
+		if f.match(&e) {
+			events = append(events, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
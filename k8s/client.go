@@ -9,10 +9,10 @@ import (
 	"strings"
 
 	"github.com/gorilla/websocket"
-	"github.com/luizalabs/sindico/controllers/kubewatch"
 	"github.com/pkg/errors"
 
 	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -87,20 +87,6 @@ func (c *Client) FindPods(namespace, labelSelector string) ([]string, error) {
 	return names, nil
 }
 
-func (c *Client) DeletePod(namespace, pod string) error {
-	return c.clientset.CoreV1().Pods(namespace).Delete(pod, &metav1.DeleteOptions{})
-}
-
-func (c *Client) SetReplicas(namespace, deploy string, replicas int32) error {
-	d, err := c.clientset.AppsV1beta2().Deployments(namespace).Get(deploy, metav1.GetOptions{})
-	if err != nil {
-		return err
-	}
-	d.Spec.Replicas = &replicas
-	_, err = c.clientset.AppsV1beta2().Deployments(namespace).Update(d)
-	return err
-}
-
 func (c *Client) execRequest(pod, container, namespace, cmd string) (*http.Request, error) {
 	u, err := url.Parse(c.cfg.Host)
 	if err != nil {
@@ -156,44 +142,35 @@ func (c *Client) Exec(pod, container, namespace, cmd string, stderr io.Writer, s
 	return wrappedRoundTripper.RoundTrip(req)
 }
 
-func (c *Client) NewClientset() (kubernetes.Interface, error) {
-	return kubernetes.NewForConfig(c.cfg)
-}
-
-func (c *Client) List(namespace string) ([]kubewatch.Pod, error) {
-	podList, err := c.clientset.CoreV1().Pods("").List(metav1.ListOptions{})
-	if err != nil {
-		return nil, err
+// GetLabelValue returns the label of namespace, empty when either is
+// missing.
+func (c *Client) GetLabelValue(namespace, label string) (string, error) {
+	ns, err := c.namespace(namespace)
+	if ns == nil {
+		return "", err
 	}
-	return convertPodList(podList.Items), nil
+	return ns.Labels[label], nil
 }
 
-func (c *Client) GetLabelValue(namespace, label string) (string, error) {
-	ns, err := c.clientset.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
-	if err != nil {
-		return "", nil
+// GetAnnotationValue returns the annotation of namespace, empty when either
+// is missing.
+func (c *Client) GetAnnotationValue(namespace, annotation string) (string, error) {
+	ns, err := c.namespace(namespace)
+	if ns == nil {
+		return "", err
 	}
-	return ns.Labels[label], nil
+	return ns.Annotations[annotation], nil
 }
 
-func convertPodList(items []k8sv1.Pod) []kubewatch.Pod {
-	pods := make([]kubewatch.Pod, 0)
-	for _, pod := range items {
-		for _, status := range pod.Status.ContainerStatuses {
-			state := "Running"
-			if status.State.Waiting != nil {
-				state = status.State.Waiting.Reason
-			} else if status.State.Terminated != nil {
-				state = status.State.Terminated.Reason
-			}
-			pods = append(pods, kubewatch.Pod{
-				Name:      pod.Name,
-				Namespace: pod.Namespace,
-				Status:    state,
-				Ready:     status.Ready})
-		}
+func (c *Client) namespace(name string) (*k8sv1.Namespace, error) {
+	ns, err := c.Namespaces().Get(name)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get namespace %s", name)
 	}
-	return pods
+	return ns, nil
 }
 
 func newInClusterK8sClient() (*Client, error) {
@@ -208,8 +185,10 @@ func newInClusterK8sClient() (*Client, error) {
 	return &Client{clientset: clientset, cfg: cfg}, nil
 }
 
-func newOutOfClusterK8sClient(conf *Config) (*Client, error) {
-	cfg, err := clientcmd.BuildConfigFromFlags("", conf.ConfigFile)
+func newOutOfClusterK8sClient(files []string, context string) (*Client, error) {
+	rules := &clientcmd.ClientConfigLoadingRules{Precedence: files}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to build outofcluster cfg")
 	}
@@ -0,0 +1,44 @@
+package k8s
+
+import (
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetLabelAndAnnotationValue(t *testing.T) {
+	ns := &k8sv1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "payments",
+		Labels:      map[string]string{"teresa.io/team": "payments"},
+		Annotations: map[string]string{"sindico.io/notification-channel": "#payments"},
+	}}
+	c := NewForClientset("", fake.NewSimpleClientset(ns), 0)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := c.StartInformers(stopCh); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		namespace, key string
+		get            func(namespace, key string) (string, error)
+		want           string
+	}{
+		{"payments", "teresa.io/team", c.GetLabelValue, "payments"},
+		{"payments", "missing", c.GetLabelValue, ""},
+		{"missing", "teresa.io/team", c.GetLabelValue, ""},
+		{"payments", "sindico.io/notification-channel", c.GetAnnotationValue, "#payments"},
+		{"payments", "missing", c.GetAnnotationValue, ""},
+		{"missing", "sindico.io/notification-channel", c.GetAnnotationValue, ""},
+	}
+	for _, tt := range tests {
+		got, err := tt.get(tt.namespace, tt.key)
+		if err != nil {
+			t.Errorf("%s %s: unexpected error %v", tt.namespace, tt.key, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.namespace, tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package k8s
+
+import (
+	"fmt"
+
+	log "github.com/inconshreveable/log15"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the events recorded by sindico.
+const (
+	ReasonHPAClamped             = "SindicoHPAClamped"
+	ReasonLimitRangeClamped      = "SindicoLimitRangeClamped"
+	ReasonServiceWithoutFirewall = "SindicoServiceWithoutFirewall"
+	ReasonCrashLoopBackOff       = "SindicoCrashLoopBackOff"
+)
+
+func newRecorder(clientset kubernetes.Interface) record.EventRecorder {
+	b := record.NewBroadcaster()
+	b.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return b.NewRecorder(scheme.Scheme, k8sv1.EventSource{Component: "sindico"})
+}
+
+// Eventf records an event on obj, shown by kubectl describe and kubectl get
+// events in its namespace. In dry-run the event is only logged.
+func (c *Client) Eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if c.dryRun != nil {
+		c.dryRun.logger.Info("would record event", "reason", reason, "message", fmt.Sprintf(messageFmt, args...))
+		return
+	}
+	if c.recorder == nil {
+		log.Debug("event dropped, no recorder", "reason", reason)
+		return
+	}
+	c.recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,55 @@
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	log "github.com/inconshreveable/log15"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEventf(t *testing.T) {
+	svc := &k8sv1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "cart", Name: "api"}}
+	tests := []struct {
+		name   string
+		client func(c *Client) *Client
+		want   []string
+	}{
+		{
+			name:   "recorded",
+			client: func(c *Client) *Client { return c },
+			want:   []string{"Warning SindicoServiceWithoutFirewall api without source ranges"},
+		},
+		{
+			name: "only logged in dry-run",
+			client: func(c *Client) *Client {
+				return c.WithDryRun(log.New(), func(string, Change) {})
+			},
+		},
+		{
+			name: "dropped without recorder",
+			client: func(c *Client) *Client {
+				c.recorder = nil
+				return c
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			c := tt.client(&Client{recorder: recorder})
+			c.Eventf(svc, k8sv1.EventTypeWarning, ReasonServiceWithoutFirewall, "%s without source ranges", svc.Name)
+			close(recorder.Events)
+			var got []string
+			for e := range recorder.Events {
+				got = append(got, e)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got events %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package k8s
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	asinformers "k8s.io/client-go/informers/autoscaling/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/informers/internalinterfaces"
+	"k8s.io/client-go/kubernetes"
+	aslisters "k8s.io/client-go/listers/autoscaling/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// informerFactory shares one informer per resource type between every
+// controller using the same Client. Informers requested after Start are
+// started right away.
+type informerFactory struct {
+	client    kubernetes.Interface
+	resync    time.Duration
+	mu        sync.Mutex
+	informers map[reflect.Type]cache.SharedIndexInformer
+	started   map[reflect.Type]bool
+	stopCh    <-chan struct{}
+}
+
+func newInformerFactory(client kubernetes.Interface, resync time.Duration) *informerFactory {
+	return &informerFactory{
+		client:    client,
+		resync:    resync,
+		informers: make(map[reflect.Type]cache.SharedIndexInformer),
+		started:   make(map[reflect.Type]bool),
+	}
+}
+
+func (f *informerFactory) InformerFor(obj runtime.Object, newFunc internalinterfaces.NewInformerFunc) cache.SharedIndexInformer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	typ := reflect.TypeOf(obj)
+	if inf, found := f.informers[typ]; found {
+		return inf
+	}
+	inf := newFunc(f.client, f.resync)
+	f.informers[typ] = inf
+	if f.stopCh != nil {
+		go inf.Run(f.stopCh)
+		f.started[typ] = true
+	}
+	return inf
+}
+
+func (f *informerFactory) Start(stopCh <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopCh = stopCh
+	for typ, inf := range f.informers {
+		if !f.started[typ] {
+			go inf.Run(stopCh)
+			f.started[typ] = true
+		}
+	}
+}
+
+func (f *informerFactory) waitForCacheSync(stopCh <-chan struct{}) error {
+	f.mu.Lock()
+	synced := make([]cache.InformerSynced, 0, len(f.informers))
+	for _, inf := range f.informers {
+		synced = append(synced, inf.HasSynced)
+	}
+	f.mu.Unlock()
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		return errors.New("stopped before informer caches were synced")
+	}
+	return nil
+}
+
+// StartInformers starts the informers behind every lister requested so far
+// and blocks until their caches are synced.
+func (c *Client) StartInformers(stopCh <-chan struct{}) error {
+	c.informers.Start(stopCh)
+	return c.informers.waitForCacheSync(stopCh)
+}
+
+func (c *Client) Pods() corelisters.PodLister {
+	return coreinformers.New(c.informers, metav1.NamespaceAll, nil).Pods().Lister()
+}
+
+func (c *Client) Namespaces() corelisters.NamespaceLister {
+	return coreinformers.New(c.informers, metav1.NamespaceAll, nil).Namespaces().Lister()
+}
+
+func (c *Client) LimitRanges() corelisters.LimitRangeLister {
+	return coreinformers.New(c.informers, metav1.NamespaceAll, nil).LimitRanges().Lister()
+}
+
+func (c *Client) Services() corelisters.ServiceLister {
+	return coreinformers.New(c.informers, metav1.NamespaceAll, nil).Services().Lister()
+}
+
+func (c *Client) HorizontalPodAutoscalers() aslisters.HorizontalPodAutoscalerLister {
+	return asinformers.New(c.informers, metav1.NamespaceAll, nil).HorizontalPodAutoscalers().Lister()
+}
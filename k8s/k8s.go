@@ -1,24 +1,120 @@
 package k8s
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/azure"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 )
 
 type Config struct {
-	ConfigFile string `split_words:"true"`
+	ConfigFile   []string      `split_words:"true"`
+	Contexts     []string      `split_words:"true"`
+	ClusterName  string        `split_words:"true"`
+	ResyncPeriod time.Duration `split_words:"true" default:"10m"`
 }
 
 type Client struct {
+	cluster   string
 	clientset kubernetes.Interface
 	cfg       *rest.Config
+	informers *informerFactory
+	dryRun    *dryRun
+	audit     func(user string, changes []Change)
+	recorder  record.EventRecorder
 }
 
+// New returns a client for the first configured cluster.
 func New(cfg *Config) (*Client, error) {
-	if cfg.ConfigFile == "" {
-		return newInClusterK8sClient()
+	clients, err := NewClusters(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return clients[0], nil
+}
+
+// NewClusters returns a client for every entry of Contexts, given as context
+// or name=context and looked up in the merged ConfigFile kubeconfigs. Without
+// contexts there's a single cluster, named ClusterName, using the current
+// context of ConfigFile or the in-cluster config.
+func NewClusters(cfg *Config) ([]*Client, error) {
+	if len(cfg.Contexts) == 0 {
+		c, err := newClient(cfg, "")
+		if err != nil {
+			return nil, err
+		}
+		c.cluster = cfg.ClusterName
+		return []*Client{c}, nil
+	}
+	if len(cfg.ConfigFile) == 0 {
+		return nil, errors.New("contexts need a config file")
+	}
+	clients := make([]*Client, 0, len(cfg.Contexts))
+	seen := make(map[string]bool)
+	for _, entry := range cfg.Contexts {
+		name, context := splitContext(entry)
+		if seen[name] {
+			return nil, fmt.Errorf("cluster %s configured twice", name)
+		}
+		seen[name] = true
+		c, err := newClient(cfg, context)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to build client of cluster %s", name)
+		}
+		c.cluster = name
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+func splitContext(entry string) (name, context string) {
+	entry = strings.TrimSpace(entry)
+	if i := strings.Index(entry, "="); i >= 0 {
+		return entry[:i], entry[i+1:]
 	}
-	return newOutOfClusterK8sClient(cfg)
+	return entry, entry
+}
+
+func newClient(cfg *Config, context string) (*Client, error) {
+	var (
+		c   *Client
+		err error
+	)
+	if len(cfg.ConfigFile) == 0 {
+		c, err = newInClusterK8sClient()
+	} else {
+		c, err = newOutOfClusterK8sClient(cfg.ConfigFile, context)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.setup(cfg.ResyncPeriod)
+	return c, nil
+}
+
+// NewForClientset returns a client of cluster using clientset, e.g. the fake
+// one in tests.
+func NewForClientset(cluster string, clientset kubernetes.Interface, resync time.Duration) *Client {
+	c := &Client{cluster: cluster, clientset: clientset}
+	c.setup(resync)
+	return c
+}
+
+func (c *Client) setup(resync time.Duration) {
+	c.informers = newInformerFactory(c.clientset, resync)
+	c.recorder = newRecorder(c.clientset)
+	// registered upfront so GetLabelValue is served from a synced cache
+	c.Namespaces()
+}
+
+// Cluster is the name of the cluster, empty for the unnamed single cluster.
+func (c *Client) Cluster() string {
+	return c.cluster
 }
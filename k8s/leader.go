@@ -0,0 +1,80 @@
+package k8s
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// same annotation used by the client-go ConfigMap resource lock
+const leaderAnnotation = "control-plane.alpha.kubernetes.io/leader"
+
+type LeaderRecord struct {
+	HolderIdentity       string      `json:"holderIdentity"`
+	LeaseDurationSeconds int         `json:"leaseDurationSeconds"`
+	AcquireTime          metav1.Time `json:"acquireTime"`
+	RenewTime            metav1.Time `json:"renewTime"`
+	LeaderTransitions    int         `json:"leaderTransitions"`
+}
+
+type LeaderLock struct {
+	client    *Client
+	namespace string
+	name      string
+	cm        *k8sv1.ConfigMap
+}
+
+func (l *LeaderLock) Get() (*LeaderRecord, error) {
+	cm, err := l.client.clientset.CoreV1().ConfigMaps(l.namespace).Get(l.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	l.cm = cm
+	rec := new(LeaderRecord)
+	if raw, found := cm.Annotations[leaderAnnotation]; found {
+		if err := json.Unmarshal([]byte(raw), rec); err != nil {
+			return nil, errors.Wrap(err, "failed to decode leader record")
+		}
+	}
+	return rec, nil
+}
+
+func (l *LeaderLock) Create(rec *LeaderRecord) error {
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	cm := &k8sv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        l.name,
+			Namespace:   l.namespace,
+			Annotations: map[string]string{leaderAnnotation: string(raw)},
+		},
+	}
+	l.cm, err = l.client.clientset.CoreV1().ConfigMaps(l.namespace).Create(cm)
+	return err
+}
+
+// Update fails with a conflict if the lock changed since the last Get.
+func (l *LeaderLock) Update(rec *LeaderRecord) error {
+	if l.cm == nil {
+		return errors.New("leader lock not initialized, call Get or Create first")
+	}
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if l.cm.Annotations == nil {
+		l.cm.Annotations = make(map[string]string)
+	}
+	l.cm.Annotations[leaderAnnotation] = string(raw)
+	l.cm, err = l.client.clientset.CoreV1().ConfigMaps(l.namespace).Update(l.cm)
+	return err
+}
+
+func (c *Client) NewLeaderLock(namespace, name string) *LeaderLock {
+	return &LeaderLock{client: c, namespace: namespace, name: name}
+}
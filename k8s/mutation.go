@@ -0,0 +1,167 @@
+package k8s
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/inconshreveable/log15"
+
+	asv1 "k8s.io/api/autoscaling/v1"
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Change is a single write to a cluster object.
+type Change struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Field     string
+	From      string
+	To        string
+}
+
+func (c Change) String() string {
+	if c.Field == "" {
+		return fmt.Sprintf("delete %s %s/%s", c.Kind, c.Namespace, c.Name)
+	}
+	return fmt.Sprintf("change %s %s/%s %s from %s to %s", c.Kind, c.Namespace, c.Name, c.Field, c.From, c.To)
+}
+
+type dryRun struct {
+	logger log.Logger
+	report func(msg string, change Change)
+}
+
+// WithDryRun returns a copy of the client whose writes are never sent to the
+// api server, only logged and passed to report as "would change X from A to B"
+// along with the change itself.
+func (c *Client) WithDryRun(logger log.Logger, report func(msg string, change Change)) *Client {
+	cp := *c
+	cp.dryRun = &dryRun{logger: logger, report: report}
+	return &cp
+}
+
+func (c *Client) DryRun() bool {
+	return c.dryRun != nil
+}
+
+// WithAudit returns a copy of the client passing the changes of every write
+// sent to the api server to audit, along with the user the write was made
+// for, if any.
+func (c *Client) WithAudit(audit func(user string, changes []Change)) *Client {
+	cp := *c
+	cp.audit = audit
+	return &cp
+}
+
+// mutate is the single path of every write made by the controllers.
+func (c *Client) mutate(user string, changes []Change, write func() error) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	if c.dryRun == nil {
+		if err := write(); err != nil {
+			return err
+		}
+		if c.audit != nil {
+			c.audit(user, changes)
+		}
+		return nil
+	}
+	for _, change := range changes {
+		msg := "would " + change.String()
+		c.dryRun.logger.Info(msg)
+		c.dryRun.report(msg, change)
+	}
+	return nil
+}
+
+// DeletePod deletes a pod on behalf of user.
+func (c *Client) DeletePod(user, namespace, pod string) error {
+	changes := []Change{{Kind: "pod", Namespace: namespace, Name: pod}}
+	return c.mutate(user, changes, func() error {
+		return c.clientset.CoreV1().Pods(namespace).Delete(pod, &metav1.DeleteOptions{})
+	})
+}
+
+// SetReplicas scales a deployment on behalf of user.
+func (c *Client) SetReplicas(user, namespace, deploy string, replicas int32) error {
+	d, err := c.clientset.AppsV1beta2().Deployments(namespace).Get(deploy, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	changes := []Change{{
+		Kind:      "deployment",
+		Namespace: namespace,
+		Name:      deploy,
+		Field:     "replicas",
+		From:      int32PtrString(d.Spec.Replicas),
+		To:        fmt.Sprint(replicas),
+	}}
+	return c.mutate(user, changes, func() error {
+		d.Spec.Replicas = &replicas
+		_, err := c.clientset.AppsV1beta2().Deployments(namespace).Update(d)
+		return err
+	})
+}
+
+// UpdateHorizontalPodAutoscaler writes updated, a modified copy of old.
+func (c *Client) UpdateHorizontalPodAutoscaler(old, updated *asv1.HorizontalPodAutoscaler) error {
+	var changes []Change
+	change := func(field, from, to string) {
+		if from != to {
+			changes = append(changes, Change{"hpa", updated.Namespace, updated.Name, field, from, to})
+		}
+	}
+	change("min replicas", int32PtrString(old.Spec.MinReplicas), int32PtrString(updated.Spec.MinReplicas))
+	change("max replicas", fmt.Sprint(old.Spec.MaxReplicas), fmt.Sprint(updated.Spec.MaxReplicas))
+	return c.mutate("", changes, func() error {
+		_, err := c.clientset.AutoscalingV1().HorizontalPodAutoscalers(updated.Namespace).Update(updated)
+		return err
+	})
+}
+
+// UpdateLimitRange writes updated, a modified copy of old.
+func (c *Client) UpdateLimitRange(old, updated *k8sv1.LimitRange) error {
+	var changes []Change
+	for i, lim := range updated.Spec.Limits {
+		var oldReq k8sv1.ResourceList
+		if i < len(old.Spec.Limits) {
+			oldReq = old.Spec.Limits[i].DefaultRequest
+		}
+		names := make([]string, 0, len(lim.DefaultRequest))
+		for name := range lim.DefaultRequest {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			from, to := "none", lim.DefaultRequest[k8sv1.ResourceName(name)]
+			if q, found := oldReq[k8sv1.ResourceName(name)]; found {
+				if q.Cmp(to) == 0 {
+					continue
+				}
+				from = q.String()
+			}
+			changes = append(changes, Change{
+				Kind:      "limitrange",
+				Namespace: updated.Namespace,
+				Name:      updated.Name,
+				Field:     fmt.Sprintf("default %s request", name),
+				From:      from,
+				To:        to.String(),
+			})
+		}
+	}
+	return c.mutate("", changes, func() error {
+		_, err := c.clientset.CoreV1().LimitRanges(updated.Namespace).Update(updated)
+		return err
+	})
+}
+
+func int32PtrString(i *int32) string {
+	if i == nil {
+		return "none"
+	}
+	return fmt.Sprint(*i)
+}
@@ -0,0 +1,52 @@
+package k8s
+
+import (
+	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConfigMapStore keeps data in a key of a ConfigMap, created on the first
+// save.
+type ConfigMapStore struct {
+	client    *Client
+	namespace string
+	name      string
+	key       string
+}
+
+func (c *Client) NewConfigMapStore(namespace, name, key string) *ConfigMapStore {
+	return &ConfigMapStore{client: c, namespace: namespace, name: name, key: key}
+}
+
+func (s *ConfigMapStore) Load() ([]byte, error) {
+	cm, err := s.client.clientset.CoreV1().ConfigMaps(s.namespace).Get(s.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(cm.Data[s.key]), nil
+}
+
+func (s *ConfigMapStore) Save(data []byte) error {
+	cms := s.client.clientset.CoreV1().ConfigMaps(s.namespace)
+	cm, err := cms.Get(s.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = cms.Create(&k8sv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace},
+			Data:       map[string]string{s.key: string(data)},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[s.key] = string(data)
+	_, err = cms.Update(cm)
+	return err
+}
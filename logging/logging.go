@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/config"
+	"github.com/pkg/errors"
+)
+
+type Config struct {
+	Format string   `split_words:"true" default:"logfmt"`
+	Level  string   `split_words:"true" default:"info"`
+	Levels []string `split_words:"true"`
+}
+
+func init() {
+	config.Register("sindico_log", &Config{})
+}
+
+func (c *Config) Validate() error {
+	if _, err := format(c.Format); err != nil {
+		return err
+	}
+	if _, err := log.LvlFromString(c.Level); err != nil {
+		return errors.Wrap(err, "invalid log level")
+	}
+	_, err := overrides(c.Levels)
+	return err
+}
+
+func format(name string) (log.Format, error) {
+	switch name {
+	case "logfmt":
+		return log.LogfmtFormat(), nil
+	case "json":
+		return log.JsonFormat(), nil
+	case "terminal":
+		return log.TerminalFormat(), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, use logfmt, json or terminal", name)
+}
+
+// overrides parses entries like kubewatch=debug or watchdog/hpa=warn.
+func overrides(levels []string) (map[string]log.Lvl, error) {
+	lvls := make(map[string]log.Lvl, len(levels))
+	for _, entry := range levels {
+		i := strings.Index(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid log level override %q, use controller=level", entry)
+		}
+		lvl, err := log.LvlFromString(entry[i+1:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid log level override %q", entry)
+		}
+		lvls[strings.TrimSpace(entry[:i])] = lvl
+	}
+	return lvls, nil
+}
+
+// Setup replaces the root handler with one writing to w in the configured
+// format and level. Records of a controller, i.e. with a controller or
+// subcontroller context, use the level set for it or for its parent.
+func Setup(w io.Writer) error {
+	var cfg Config
+	if err := config.Process("sindico_log", &cfg); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	f, _ := format(cfg.Format)
+	lvl, _ := log.LvlFromString(cfg.Level)
+	lvls, _ := overrides(cfg.Levels)
+	h := log.CallerFileHandler(log.StreamHandler(w, f))
+	log.Root().SetHandler(levelHandler(lvl, lvls, h))
+	return nil
+}
+
+func levelHandler(lvl log.Lvl, lvls map[string]log.Lvl, h log.Handler) log.Handler {
+	return log.FuncHandler(func(r *log.Record) error {
+		max := lvl
+		if name := controller(r.Ctx); name != "" {
+			if l, found := lvls[name]; found {
+				max = l
+			} else if l, found := lvls[strings.SplitN(name, "/", 2)[0]]; found {
+				max = l
+			}
+		}
+		if r.Lvl > max {
+			return nil
+		}
+		return h.Log(r)
+	})
+}
+
+// controller returns the name of the controller logging, e.g. watchdog/hpa
+// for a record with controller=watchdog and subcontroller=hpa.
+func controller(ctx []interface{}) string {
+	var ctrl, sub string
+	for i := 0; i+1 < len(ctx); i += 2 {
+		switch ctx[i] {
+		case "controller":
+			ctrl, _ = ctx[i+1].(string)
+		case "subcontroller":
+			sub, _ = ctx[i+1].(string)
+		}
+	}
+	if sub == "" {
+		return ctrl
+	}
+	if ctrl == "" {
+		return sub
+	}
+	return ctrl + "/" + sub
+}
@@ -1,12 +1,22 @@
 package main
 
 import (
-	log "github.com/inconshreveable/log15"
-	"github.com/luizalabs/sindico/manager"
+	"fmt"
+	"os"
+
+	"github.com/luizalabs/sindico/cli"
+	_ "github.com/luizalabs/sindico/controllers/digest"
+	_ "github.com/luizalabs/sindico/controllers/etcdbackup"
+	_ "github.com/luizalabs/sindico/controllers/kubewatch"
+	_ "github.com/luizalabs/sindico/controllers/srebot"
+	_ "github.com/luizalabs/sindico/controllers/watchdog"
+	"github.com/luizalabs/sindico/logging"
 )
 
 func main() {
-	h := log.CallerFileHandler(log.StdoutHandler)
-	log.Root().SetHandler(h)
-	manager.Run()
+	if err := logging.Setup(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid log config:", err)
+		os.Exit(2)
+	}
+	os.Exit(cli.Run(os.Args[1:]))
 }
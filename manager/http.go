@@ -0,0 +1,156 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/notification"
+	"github.com/luizalabs/sindico/status"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type leaderStatus struct {
+	Enabled  bool   `json:"enabled"`
+	IsLeader bool   `json:"isLeader"`
+	Leader   string `json:"leader,omitempty"`
+}
+
+type statusResponse struct {
+	Ready       bool            `json:"ready"`
+	Healthy     bool            `json:"healthy"`
+	Leader      leaderStatus    `json:"leaderElection"`
+	Controllers []status.Report `json:"controllers"`
+}
+
+type server struct {
+	mu       sync.Mutex
+	ready    bool
+	le       *leaderElector
+	nt       *notification.Client
+	srv      *http.Server
+	restarts int
+	window   time.Duration
+}
+
+func (s *server) setReady(ready bool) {
+	s.mu.Lock()
+	s.ready = ready
+	s.mu.Unlock()
+}
+
+func (s *server) isReady() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready
+}
+
+func (s *server) setLeaderElector(le *leaderElector) {
+	s.mu.Lock()
+	s.le = le
+	s.mu.Unlock()
+}
+
+func (s *server) unhealthy() []string {
+	names := []string{}
+	for _, r := range status.Reports() {
+		if !r.Healthy(s.restarts, s.window) {
+			name := r.Name
+			if r.Cluster != "" {
+				name = r.Cluster + "/" + name
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (s *server) healthz(w http.ResponseWriter, r *http.Request) {
+	if names := s.unhealthy(); len(names) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "controllers not running or crash looping: %v\n", names)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *server) readyz(w http.ResponseWriter, r *http.Request) {
+	if !s.isReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "not ready")
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *server) status(w http.ResponseWriter, r *http.Request) {
+	resp := statusResponse{
+		Ready:       s.isReady(),
+		Healthy:     len(s.unhealthy()) == 0,
+		Controllers: status.Reports(),
+	}
+	s.mu.Lock()
+	if s.le != nil {
+		resp.Leader = leaderStatus{Enabled: true, IsLeader: s.le.IsLeader(), Leader: s.le.Leader()}
+	}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resp); err != nil {
+		log.Error("failed to encode status", "err", err)
+	}
+}
+
+// alerts lists the active alerts, optionally filtered by the cluster,
+// controller, namespace, team, severity and condition query parameters.
+func (s *server) alerts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := notification.Meta{
+		Cluster:    q.Get("cluster"),
+		Controller: q.Get("controller"),
+		Namespace:  q.Get("namespace"),
+		Team:       q.Get("team"),
+		Severity:   q.Get("severity"),
+		Condition:  q.Get("condition"),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.nt.Alerts(f)); err != nil {
+		log.Error("failed to encode alerts", "err", err)
+	}
+}
+
+func (s *server) start() {
+	go func() {
+		log.Info("starting http server", "addr", s.srv.Addr)
+		if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("http server failed", "err", err)
+		}
+	}()
+}
+
+func (s *server) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		log.Error("failed to stop http server", "err", err)
+	}
+}
+
+func newServer(cfg *Config, nt *notification.Client) *server {
+	s := &server{nt: nt, restarts: cfg.UnhealthyRestarts, window: cfg.UnhealthyAfter}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.HandleFunc("/status", s.status)
+	mux.HandleFunc("/alerts", s.alerts)
+	mux.Handle("/metrics", promhttp.Handler())
+	s.srv = &http.Server{Addr: cfg.HTTPAddr, Handler: mux}
+	return s
+}
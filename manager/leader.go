@@ -0,0 +1,207 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/k8s"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+type LeaderLock interface {
+	Get() (*k8s.LeaderRecord, error)
+	Create(rec *k8s.LeaderRecord) error
+	Update(rec *k8s.LeaderRecord) error
+}
+
+type leaderElector struct {
+	lock          LeaderLock
+	identity      string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+	logger        log.Logger
+
+	mu           sync.Mutex
+	observed     k8s.LeaderRecord
+	observedTime time.Time
+	leading      bool
+}
+
+// run blocks until ctx is done or the leadership is lost. The callback is
+// called with a context cancelled when leading stops and must return before
+// the lock is released.
+func (le *leaderElector) run(ctx context.Context, onStartedLeading func(ctx context.Context)) {
+	if !le.acquire(ctx) {
+		return
+	}
+	leaderCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		onStartedLeading(leaderCtx)
+	}()
+	le.renew(leaderCtx)
+	cancel()
+	<-done
+	le.release()
+}
+
+func (le *leaderElector) acquire(ctx context.Context) bool {
+	le.logger.Info("waiting for leadership", "identity", le.identity)
+	for {
+		if le.tryAcquireOrRenew() {
+			le.setLeading(true)
+			le.logger.Info("leadership acquired", "identity", le.identity)
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait.Jitter(le.retryPeriod, 1.2)):
+		}
+	}
+}
+
+func (le *leaderElector) renew(ctx context.Context) {
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(le.retryPeriod):
+		}
+		if le.tryAcquireOrRenew() {
+			lastRenew = time.Now()
+			continue
+		}
+		if time.Since(lastRenew) > le.renewDeadline {
+			le.setLeading(false)
+			le.logger.Error("leadership lost", "identity", le.identity)
+			return
+		}
+	}
+}
+
+// release clears the holder of the lock, so the next replica doesn't wait
+// for the lease to expire. The transitions and acquire time are kept.
+func (le *leaderElector) release() {
+	if !le.IsLeader() {
+		return
+	}
+	old, err := le.lock.Get()
+	if err != nil {
+		le.logger.Error("failed to get leader lock", "err", err)
+		return
+	}
+	if old.HolderIdentity != le.identity {
+		le.setLeading(false)
+		return
+	}
+	rec := *old
+	rec.HolderIdentity = ""
+	rec.LeaseDurationSeconds = 1
+	rec.RenewTime = metav1.Now()
+	if err := le.lock.Update(&rec); err != nil {
+		le.logger.Error("failed to release leader lock", "err", err)
+		return
+	}
+	le.setLeading(false)
+	le.logger.Info("leadership released", "identity", le.identity)
+}
+
+func (le *leaderElector) tryAcquireOrRenew() bool {
+	now := metav1.Now()
+	rec := k8s.LeaderRecord{
+		HolderIdentity:       le.identity,
+		LeaseDurationSeconds: int(le.leaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+	old, err := le.lock.Get()
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			le.logger.Error("failed to get leader lock", "err", err)
+			return false
+		}
+		if err := le.lock.Create(&rec); err != nil {
+			le.logger.Error("failed to create leader lock", "err", err)
+			return false
+		}
+		le.observe(rec, now.Time)
+		return true
+	}
+
+	le.observe(*old, now.Time)
+	le.mu.Lock()
+	expired := le.observedTime.Add(le.leaseDuration).Before(now.Time)
+	le.mu.Unlock()
+	if old.HolderIdentity != "" && old.HolderIdentity != le.identity && !expired {
+		return false
+	}
+
+	if old.HolderIdentity == le.identity {
+		rec.AcquireTime = old.AcquireTime
+		rec.LeaderTransitions = old.LeaderTransitions
+	} else {
+		rec.LeaderTransitions = old.LeaderTransitions + 1
+	}
+	if err := le.lock.Update(&rec); err != nil {
+		le.logger.Error("failed to update leader lock", "err", err)
+		return false
+	}
+	le.observe(rec, now.Time)
+	return true
+}
+
+func (le *leaderElector) observe(rec k8s.LeaderRecord, now time.Time) {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	if le.observed == rec {
+		return
+	}
+	if le.observed.HolderIdentity != rec.HolderIdentity && rec.HolderIdentity != "" {
+		le.logger.Info("new leader observed", "leader", rec.HolderIdentity)
+	}
+	le.observed = rec
+	le.observedTime = now
+}
+
+func (le *leaderElector) setLeading(leading bool) {
+	le.mu.Lock()
+	le.leading = leading
+	le.mu.Unlock()
+}
+
+func (le *leaderElector) IsLeader() bool {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	return le.leading
+}
+
+func (le *leaderElector) Leader() string {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	return le.observed.HolderIdentity
+}
+
+func newLeaderElector(k *k8s.Client, cfg *Config) *leaderElector {
+	identity := cfg.LeaderElectionIdentity
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	return &leaderElector{
+		lock:          k.NewLeaderLock(cfg.LeaderElectionNamespace, cfg.LeaderElectionName),
+		identity:      identity,
+		leaseDuration: cfg.LeaseDuration,
+		renewDeadline: cfg.RenewDeadline,
+		retryPeriod:   cfg.RetryPeriod,
+		logger:        log.New("component", "leaderelection"),
+	}
+}
@@ -0,0 +1,195 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/k8s"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const lockNs, lockName = "sindico", "sindico"
+
+func newTestElector(k *k8s.Client, identity string) *leaderElector {
+	return &leaderElector{
+		lock:          k.NewLeaderLock(lockNs, lockName),
+		identity:      identity,
+		leaseDuration: 15 * time.Second,
+		renewDeadline: 50 * time.Millisecond,
+		retryPeriod:   10 * time.Millisecond,
+		logger:        log.New("component", "leaderelection"),
+	}
+}
+
+func newTestLock(t *testing.T, rec *k8s.LeaderRecord) *k8s.Client {
+	k := k8s.NewForClientset("", fake.NewSimpleClientset(), 0)
+	if rec != nil {
+		if err := k.NewLeaderLock(lockNs, lockName).Create(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return k
+}
+
+func getRecord(t *testing.T, k *k8s.Client) *k8s.LeaderRecord {
+	rec, err := k.NewLeaderLock(lockNs, lockName).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestTryAcquireOrRenew(t *testing.T) {
+	acquired := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	tests := []struct {
+		name            string
+		lock            *k8s.LeaderRecord
+		observedAge     time.Duration
+		want            bool
+		wantHolder      string
+		wantTransitions int
+		keepAcquireTime bool
+	}{
+		{name: "no lock", want: true, wantHolder: "a"},
+		{
+			name:       "released lock",
+			lock:       &k8s.LeaderRecord{LeaseDurationSeconds: 1, LeaderTransitions: 2},
+			want:       true,
+			wantHolder: "a", wantTransitions: 3,
+		},
+		{
+			name:       "held by another replica",
+			lock:       &k8s.LeaderRecord{HolderIdentity: "b", LeaseDurationSeconds: 15, AcquireTime: acquired, RenewTime: acquired},
+			want:       false,
+			wantHolder: "b",
+		},
+		{
+			name:        "expired lease of another replica",
+			lock:        &k8s.LeaderRecord{HolderIdentity: "b", LeaseDurationSeconds: 15, AcquireTime: acquired, RenewTime: acquired, LeaderTransitions: 1},
+			observedAge: time.Minute,
+			want:        true,
+			wantHolder:  "a", wantTransitions: 2,
+		},
+		{
+			name:       "renewed by the holder",
+			lock:       &k8s.LeaderRecord{HolderIdentity: "a", LeaseDurationSeconds: 15, AcquireTime: acquired, RenewTime: acquired, LeaderTransitions: 4},
+			want:       true,
+			wantHolder: "a", wantTransitions: 4,
+			keepAcquireTime: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestLock(t, tt.lock)
+			le := newTestElector(k, "a")
+			if tt.observedAge > 0 {
+				// the lease expires when the record is unchanged for its
+				// duration since it was first observed
+				le.observe(*tt.lock, time.Now().Add(-tt.observedAge))
+			}
+			if got := le.tryAcquireOrRenew(); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			rec := getRecord(t, k)
+			if rec.HolderIdentity != tt.wantHolder {
+				t.Errorf("got holder %q, want %q", rec.HolderIdentity, tt.wantHolder)
+			}
+			if rec.LeaderTransitions != tt.wantTransitions {
+				t.Errorf("got %d transitions, want %d", rec.LeaderTransitions, tt.wantTransitions)
+			}
+			if tt.keepAcquireTime && !rec.AcquireTime.Equal(&acquired) {
+				t.Errorf("got acquire time %s, want %s", rec.AcquireTime, acquired)
+			}
+			if le.Leader() != tt.wantHolder {
+				t.Errorf("got observed leader %q, want %q", le.Leader(), tt.wantHolder)
+			}
+		})
+	}
+}
+
+func TestLeaderRenewLost(t *testing.T) {
+	k := newTestLock(t, nil)
+	le := newTestElector(k, "a")
+	if !le.acquire(context.Background()) {
+		t.Fatal("expected to acquire the lock")
+	}
+	// another replica takes over, e.g. after a long pause of this one
+	other := newTestElector(k, "b")
+	other.observe(*getRecord(t, k), time.Now().Add(-time.Minute))
+	if !other.tryAcquireOrRenew() {
+		t.Fatal("expected the other replica to take over the expired lock")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	le.renew(ctx)
+	if ctx.Err() != nil {
+		t.Fatal("renew didn't give up after the renew deadline")
+	}
+	if le.IsLeader() {
+		t.Error("expected the leadership to be lost")
+	}
+}
+
+func TestLeaderTakeoverAfterExpiry(t *testing.T) {
+	k := newTestLock(t, nil)
+	le := newTestElector(k, "a")
+	if !le.acquire(context.Background()) {
+		t.Fatal("expected to acquire the lock")
+	}
+
+	// a stops renewing without releasing, e.g. it was killed
+	other := newTestElector(k, "b")
+	other.leaseDuration = 100 * time.Millisecond
+	if other.tryAcquireOrRenew() {
+		t.Fatal("expected the lock to be held until the lease expires")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if !other.acquire(ctx) {
+		t.Fatal("expected the other replica to take over the expired lock")
+	}
+	if elapsed := time.Since(start); elapsed < other.leaseDuration/2 {
+		t.Errorf("took over after %s, want after the lease of %s", elapsed, other.leaseDuration)
+	}
+	rec := getRecord(t, k)
+	if rec.HolderIdentity != "b" || rec.LeaderTransitions != 1 {
+		t.Errorf("got %+v, want b holding the lock after 1 transition", rec)
+	}
+	if !other.IsLeader() || other.Leader() != "b" {
+		t.Errorf("got leading %v, leader %q, want b leading", other.IsLeader(), other.Leader())
+	}
+}
+
+func TestLeaderRunReleases(t *testing.T) {
+	k := newTestLock(t, &k8s.LeaderRecord{LeaseDurationSeconds: 1, LeaderTransitions: 2})
+	le := newTestElector(k, "a")
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	go func() {
+		<-started
+		cancel()
+	}()
+	le.run(ctx, func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+	})
+	if le.IsLeader() {
+		t.Error("expected the leadership to be released")
+	}
+	rec := getRecord(t, k)
+	if rec.HolderIdentity != "" || rec.LeaseDurationSeconds != 1 {
+		t.Errorf("got %+v, want a released lock", rec)
+	}
+	if rec.LeaderTransitions != 3 || rec.AcquireTime.IsZero() {
+		t.Errorf("got %+v, want the transitions and acquire time kept", rec)
+	}
+	// the lock is free for the next replica right away
+	if !newTestElector(k, "b").tryAcquireOrRenew() {
+		t.Error("expected another replica to acquire the released lock")
+	}
+}
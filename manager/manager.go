@@ -2,142 +2,410 @@ package manager
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/kelseyhightower/envconfig"
-	"github.com/luizalabs/sindico/controllers/etcdbackup"
-	"github.com/luizalabs/sindico/controllers/kubewatch"
-	"github.com/luizalabs/sindico/controllers/srebot"
-	"github.com/luizalabs/sindico/controllers/watchdog"
+	"github.com/luizalabs/sindico/audit"
+	"github.com/luizalabs/sindico/config"
+	"github.com/luizalabs/sindico/controllers"
+	"github.com/luizalabs/sindico/history"
 	"github.com/luizalabs/sindico/k8s"
+	"github.com/luizalabs/sindico/logging"
 	"github.com/luizalabs/sindico/notification"
+	"github.com/luizalabs/sindico/scheduler"
 	"github.com/luizalabs/sindico/storage"
 	"github.com/pkg/errors"
 )
 
-type Controller interface {
-	Run(stopCh <-chan struct{})
+type Config struct {
+	ShutdownTimeout         time.Duration `split_words:"true" default:"30s"`
+	LeaderElection          bool          `split_words:"true" default:"false"`
+	LeaderElectionNamespace string        `split_words:"true" default:"sindico"`
+	LeaderElectionName      string        `split_words:"true" default:"sindico"`
+	LeaderElectionIdentity  string        `split_words:"true"`
+	LeaseDuration           time.Duration `split_words:"true" default:"15s"`
+	RenewDeadline           time.Duration `split_words:"true" default:"10s"`
+	RetryPeriod             time.Duration `split_words:"true" default:"2s"`
+	EnabledControllers      []string      `split_words:"true" default:"*"`
+	DisabledControllers     []string      `split_words:"true"`
+	HTTPAddr                string        `envconfig:"http_addr" default:":8080"`
+	RestartBackoff          time.Duration `split_words:"true" default:"10s"`
+	RestartBackoffMax       time.Duration `split_words:"true" default:"5m"`
+	UnhealthyRestarts       int           `split_words:"true" default:"5"`
+	UnhealthyAfter          time.Duration `split_words:"true" default:"15m"`
+	NotificationChannel     string        `split_words:"true" default:"#alerts"`
+	Timezone                string        `split_words:"true" default:"Local"`
+	DryRun                  bool          `split_words:"true" default:"false"`
+	DryRunControllers       []string      `split_words:"true"`
+}
+
+// FileConfig is read only from env vars, before the config file is loaded.
+type FileConfig struct {
+	ConfigFile           string        `split_words:"true"`
+	ConfigReloadInterval time.Duration `split_words:"true" default:"30s"`
+}
+
+func init() {
+	config.Register("sindico_manager", &Config{})
+	config.Register("sindico_k8s", &k8s.Config{})
+	config.Register("sindico_storage", &storage.Config{})
+	config.Register("sindico_notification", &notification.Config{})
+	config.Register("sindico_audit", &audit.Config{})
+	config.Register("sindico_history", &history.Config{})
+}
+
+type namedController struct {
+	cluster string
+	name    string
+	nt      Notification
+	controllers.Controller
+}
+
+// id is the name prefixed by the cluster, if any, e.g. prod/kubewatch.
+func (c namedController) id() string {
+	if c.cluster == "" {
+		return c.name
+	}
+	return c.cluster + "/" + c.name
 }
 
 func newK8s() (*k8s.Client, error) {
 	var cfg k8s.Config
-	if err := envconfig.Process("sindico_k8s", &cfg); err != nil {
+	if err := config.Process("sindico_k8s", &cfg); err != nil {
 		return nil, err
 	}
 	return k8s.New(&cfg)
 }
 
+func newK8sClusters() ([]*k8s.Client, error) {
+	var cfg k8s.Config
+	if err := config.Process("sindico_k8s", &cfg); err != nil {
+		return nil, err
+	}
+	return k8s.NewClusters(&cfg)
+}
+
+// sharedK8s builds a single k8s client per cluster for every controller, so
+// they all read from the same informer caches. Controllers in dry-run get a
+// view of it reporting their writes instead of sending them.
+type sharedK8s struct {
+	clients []*k8s.Client
+	audit   *audit.Log
+	history *history.Log
+	dryRun  func(name string) bool
+	channel string
+}
+
+func (s *sharedK8s) clusters() ([]*k8s.Client, error) {
+	if s.clients == nil {
+		clients, err := newK8sClusters()
+		if err != nil {
+			return nil, err
+		}
+		s.clients = clients
+	}
+	return s.clients, nil
+}
+
+func (s *sharedK8s) get(k *k8s.Client, name string, nt Notification) *k8s.Client {
+	if !s.dryRun(name) {
+		return k.WithAudit(s.audit.Recorder(k.Cluster(), name))
+	}
+	logger := controllers.Logger(k.Cluster(), "controller", name, "dry_run", true)
+	return k.WithDryRun(logger, func(msg string, change k8s.Change) {
+		// the condition and object make repeated reports of the same change
+		// go through the alerts dedup
+		m := &notification.Message{
+			Meta: notification.Meta{
+				Severity:  notification.SeverityInfo,
+				Namespace: change.Namespace,
+				Object:    strings.TrimSpace(fmt.Sprintf("%s/%s %s", change.Kind, change.Name, change.Field)),
+				Condition: "DryRun",
+			},
+			Title: fmt.Sprintf("%s (dry run)", name),
+			Text:  msg,
+		}
+		if err := nt.Send(m, s.channel); err != nil {
+			logger.Error("can't send message", "err", err)
+		}
+	})
+}
+
+func (s *sharedK8s) startInformers(stopCh <-chan struct{}) error {
+	if len(s.clients) == 0 {
+		return nil
+	}
+	log.Info("waiting for informer caches to sync")
+	for _, k := range s.clients {
+		if err := k.StartInformers(stopCh); err != nil {
+			return errors.Wrapf(err, "cluster %s", k.Cluster())
+		}
+	}
+	return nil
+}
+
 func newStorage() (*storage.Client, error) {
 	var cfg storage.Config
-	if err := envconfig.Process("sindico_storage", &cfg); err != nil {
+	if err := config.Process("sindico_storage", &cfg); err != nil {
 		return nil, err
 	}
 	return storage.New(&cfg), nil
 }
 
-func newNotification() (*notification.Client, error) {
-	var cfg notification.Config
-	if err := envconfig.Process("sindico_notification", &cfg); err != nil {
+func newAudit(st *storage.Client) (*audit.Log, error) {
+	var cfg audit.Config
+	if err := config.Process("sindico_audit", &cfg); err != nil {
 		return nil, err
 	}
-	return notification.New(&cfg), nil
+	return audit.New(st, &cfg), nil
 }
 
-func Run() {
-	ctrls, err := newControllers()
-	if err != nil {
-		log.Error("failed to build controllers", "err", err)
-		return
+func newHistory(st *storage.Client) (*history.Log, error) {
+	var cfg history.Config
+	if err := config.Process("sindico_history", &cfg); err != nil {
+		return nil, err
 	}
-	run(ctrls)
-	select {}
+	return history.New(st, &cfg), nil
 }
 
-func newControllers() ([]Controller, error) {
-	ctrls := []Controller{}
-
-	ctrl, err := newEtcdBackup()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to build etcdbackup ctrl")
+func newNotification(st *storage.Client) (*notification.Client, error) {
+	var cfg notification.Config
+	if err := config.Process("sindico_notification", &cfg); err != nil {
+		return nil, err
+	}
+	var outbox notification.OutboxStore
+	switch {
+	case cfg.OutboxConfigMap != "":
+		ns, name, err := cfg.OutboxConfigMapName()
+		if err != nil {
+			return nil, err
+		}
+		k, err := newK8s()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build outbox k8s client")
+		}
+		outbox = k.NewConfigMapStore(ns, name, "outbox.json")
+	case cfg.OutboxFile != "":
+		outbox = notification.NewFileStore(cfg.OutboxFile)
 	}
-	ctrls = append(ctrls, ctrl)
+	return notification.New(&cfg, st, outbox)
+}
 
-	ctrl, err = newKubeWatch()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to build kubewatch ctrl")
+// LoadConfigFile loads the config file given by SINDICO_CONFIG_FILE, if any.
+func LoadConfigFile() (*FileConfig, error) {
+	var fileCfg FileConfig
+	if err := envconfig.Process("sindico", &fileCfg); err != nil {
+		return nil, errors.Wrap(err, "failed to process env vars")
+	}
+	if fileCfg.ConfigFile != "" {
+		f, err := config.Load(fileCfg.ConfigFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid config file")
+		}
+		config.Set(f)
+		log.Info("config file loaded", "path", fileCfg.ConfigFile)
 	}
-	ctrls = append(ctrls, ctrl)
+	return &fileCfg, nil
+}
 
-	ctrl, err = newSrebot()
+func Run() {
+	fileCfg, err := LoadConfigFile()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to build srebot ctrl")
+		log.Error("failed to load config", "err", err)
+		return
 	}
-	ctrls = append(ctrls, ctrl)
-
-	ctrl, err = newWatchdog()
+	if err := logging.Setup(os.Stdout); err != nil {
+		log.Error("invalid log config", "err", err)
+		return
+	}
+	var cfg Config
+	if err := config.Process("sindico_manager", &cfg); err != nil {
+		log.Error("failed to process config", "err", err)
+		return
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to build watchdog ctrl")
+		log.Error("invalid timezone", "err", err)
+		return
 	}
-	ctrls = append(ctrls, ctrl)
-
-	return ctrls, nil
-}
-
-func newEtcdBackup() (Controller, error) {
-	k, err := newK8s()
+	scheduler.SetLocation(loc)
+	sel, err := newSelection(&cfg)
 	if err != nil {
-		return nil, err
+		log.Error("invalid controller selection", "err", err)
+		return
+	}
+	enabled, disabled := sel.summary()
+	log.Info("controllers selected", "enabled", strings.Join(enabled, ","), "disabled", strings.Join(disabled, ","))
+	if dryRun := sel.dryRunSummary(); len(dryRun) > 0 {
+		log.Warn("controllers in dry-run", "controllers", strings.Join(dryRun, ","))
 	}
 	st, err := newStorage()
 	if err != nil {
-		return nil, err
+		log.Error("failed to build storage client", "err", err)
+		return
 	}
-	nt, err := newNotification()
+	nt, err := newNotification(st)
 	if err != nil {
-		return nil, err
+		log.Error("failed to build notification client", "err", err)
+		return
 	}
-	ctrl := etcdbackup.NewController(k, st, nt)
-	return ctrl, nil
-}
-
-func newKubeWatch() (Controller, error) {
-	k, err := newK8s()
+	aud, err := newAudit(st)
 	if err != nil {
-		return nil, err
+		log.Error("failed to build audit log", "err", err)
+		return
 	}
-	nt, err := newNotification()
+	hist, err := newHistory(st)
 	if err != nil {
-		return nil, err
+		log.Error("failed to build history log", "err", err)
+		return
+	}
+	sk := &sharedK8s{audit: aud, history: hist, dryRun: sel.isDryRun, channel: cfg.NotificationChannel}
+	ctrls, err := newControllers(sel, sk, st, nt)
+	if err != nil {
+		log.Error("failed to build controllers", "err", err)
+		return
+	}
+	sup := newSupervisor(&cfg)
+	srv := newServer(&cfg, nt)
+	srv.start()
+	defer srv.stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	go waitSignal(cancel)
+	if fileCfg.ConfigFile != "" {
+		go config.Watch(fileCfg.ConfigFile, fileCfg.ConfigReloadInterval, ctx.Done(), func() {
+			if err := logging.Setup(os.Stdout); err != nil {
+				log.Error("invalid log config", "err", err)
+			}
+		})
+	}
+	if err := sk.startInformers(ctx.Done()); err != nil {
+		log.Error("failed to start informers", "err", err)
+		return
+	}
+	if !cfg.LeaderElection {
+		srv.setReady(true)
+		runUntil(ctx, sup, ctrls, nt, cfg.ShutdownTimeout)
+		srv.setReady(false)
+		return
 	}
-	ctrl := kubewatch.NewController(k, nt)
-	return ctrl, nil
-}
-
-func newSrebot() (Controller, error) {
 	k, err := newK8s()
 	if err != nil {
-		return nil, err
+		log.Error("failed to build leader election client", "err", err)
+		return
 	}
-	ctrl := srebot.NewController(k)
-	return ctrl, nil
+	le := newLeaderElector(k, &cfg)
+	srv.setLeaderElector(le)
+	srv.setReady(true)
+	le.run(ctx, func(ctx context.Context) {
+		runUntil(ctx, sup, ctrls, nt, cfg.ShutdownTimeout)
+		srv.setReady(false)
+	})
 }
 
-func newWatchdog() (Controller, error) {
-	k, err := newK8s()
-	if err != nil {
-		return nil, err
+// runUntil runs the controllers, and delivers the queued notifications,
+// until ctx is done. The alerts state is saved after the controllers
+// stopped, so their last changes are kept.
+func runUntil(ctx context.Context, sup *supervisor, ctrls []namedController, nt *notification.Client, timeout time.Duration) {
+	go nt.Run(ctx.Done())
+	done := run(ctx, sup, ctrls)
+	<-ctx.Done()
+	drain(done, timeout)
+	nt.Flush()
+}
+
+func waitSignal(cancel context.CancelFunc) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigCh
+	log.Info("shutting down", "signal", sig)
+	cancel()
+}
+
+// newControllers builds every enabled controller of the registry once per
+// cluster, with the clients shared by the controllers of that cluster.
+func newControllers(sel *selection, sk *sharedK8s, st *storage.Client, nt *notification.Client) ([]namedController, error) {
+	ctrls := []namedController{}
+	names := []string{}
+	for _, name := range controllers.Registered() {
+		if sel.isEnabled(name) {
+			names = append(names, name)
+		}
 	}
-	nt, err := newNotification()
+	if len(names) == 0 {
+		return ctrls, nil
+	}
+	clients, err := sk.clusters()
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "failed to build k8s clients")
+	}
+	for i, k := range clients {
+		cnt := nt.WithCluster(k.Cluster()).WithNamespaces(k)
+		for _, name := range names {
+			if i > 0 && controllers.PrimaryOnly(name) {
+				continue
+			}
+			cnt := cnt.WithController(name)
+			deps := &controllers.Deps{K8s: sk.get(k, name, cnt), Storage: st, Notification: cnt, Audit: sk.audit, History: sk.history}
+			ctrl, err := controllers.New(name, deps)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to build %s ctrl", name)
+			}
+			ctrls = append(ctrls, namedController{k.Cluster(), name, cnt, ctrl})
+		}
 	}
-	ctrl := watchdog.NewController(k, nt)
-	return ctrl, nil
+	return ctrls, nil
 }
 
-func run(ctrls []Controller) {
-	ctx := context.Background()
+func run(ctx context.Context, sup *supervisor, ctrls []namedController) map[string]chan struct{} {
+	done := make(map[string]chan struct{}, len(ctrls))
 	for _, ctrl := range ctrls {
-		go ctrl.Run(ctx.Done())
+		ch := make(chan struct{})
+		done[ctrl.id()] = ch
+		go func(ctrl namedController) {
+			defer close(ch)
+			sup.supervise(ctx, ctrl)
+		}(ctrl)
+	}
+	return done
+}
+
+func drain(done map[string]chan struct{}, timeout time.Duration) {
+	var mu sync.Mutex
+	pending := make(map[string]bool, len(done))
+	for name := range done {
+		pending[name] = true
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(done))
+	for name, ch := range done {
+		go func(name string, ch chan struct{}) {
+			defer wg.Done()
+			<-ch
+			mu.Lock()
+			delete(pending, name)
+			mu.Unlock()
+		}(name, ch)
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		log.Info("all controllers stopped")
+	case <-time.After(timeout):
+		mu.Lock()
+		defer mu.Unlock()
+		for name := range pending {
+			log.Error("controller did not stop in time", "controller", name, "timeout", timeout)
+		}
 	}
 }
@@ -0,0 +1,128 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/config"
+	"github.com/luizalabs/sindico/controllers"
+	"github.com/luizalabs/sindico/controllers/etcdbackup"
+	"github.com/pkg/errors"
+)
+
+const allControllers = "*"
+
+type selection struct {
+	enabled  map[string]bool
+	disabled map[string]bool
+	dryRun   map[string]bool
+}
+
+// isEnabled reports whether a controller or subcontroller (parent/name) was
+// selected. Selecting or disabling a parent applies to all its children.
+func (s *selection) isEnabled(name string) bool {
+	parent := controllers.Parent(name)
+	if s.disabled[name] || s.disabled[parent] {
+		return false
+	}
+	return s.enabled[allControllers] || s.enabled[name] || s.enabled[parent]
+}
+
+// isDryRun reports whether a controller or subcontroller should only report
+// its writes. Like the other selections it applies from parents to children.
+func (s *selection) isDryRun(name string) bool {
+	parent := controllers.Parent(name)
+	return s.dryRun[allControllers] || s.dryRun[name] || s.dryRun[parent]
+}
+
+func (s *selection) dryRunSummary() []string {
+	names := []string{}
+	for _, name := range controllers.Names() {
+		if s.isEnabled(name) && s.isDryRun(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (s *selection) summary() (enabled, disabled []string) {
+	for _, name := range controllers.Names() {
+		if s.isEnabled(name) {
+			enabled = append(enabled, name)
+		} else {
+			disabled = append(disabled, name)
+		}
+	}
+	return enabled, disabled
+}
+
+// Validate rejects the selections of controllers that aren't registered.
+func (c *Config) Validate() error {
+	lists := []struct {
+		key   string
+		names []string
+	}{
+		{"enabled controllers", c.EnabledControllers},
+		{"disabled controllers", c.DisabledControllers},
+		{"dry run controllers", c.DryRunControllers},
+	}
+	for _, l := range lists {
+		if err := addNames(make(map[string]bool), l.names); err != nil {
+			return errors.Wrapf(err, "invalid %s", l.key)
+		}
+	}
+	return nil
+}
+
+func newSelection(cfg *Config) (*selection, error) {
+	s := &selection{
+		enabled:  make(map[string]bool),
+		disabled: make(map[string]bool),
+		dryRun:   make(map[string]bool),
+	}
+	if err := addNames(s.enabled, cfg.EnabledControllers); err != nil {
+		return nil, err
+	}
+	if err := addNames(s.disabled, cfg.DisabledControllers); err != nil {
+		return nil, err
+	}
+	if err := addNames(s.dryRun, cfg.DryRunControllers); err != nil {
+		return nil, err
+	}
+	if cfg.DryRun {
+		s.dryRun[allControllers] = true
+	}
+	var etcdCfg etcdbackup.EtcdBackupConfig
+	if err := config.Process("sindico_etcd_backup", &etcdCfg); err != nil {
+		return nil, err
+	}
+	if etcdCfg.Disabled != "" {
+		log.Warn("SINDICO_ETCD_BACKUP_DISABLED is deprecated, use SINDICO_MANAGER_DISABLED_CONTROLLERS")
+		s.disabled["etcdbackup"] = true
+	}
+	return s, nil
+}
+
+func addNames(set map[string]bool, names []string) error {
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name != allControllers && !isKnownController(name) {
+			return fmt.Errorf("unknown controller %s", name)
+		}
+		set[name] = true
+	}
+	return nil
+}
+
+func isKnownController(name string) bool {
+	for _, known := range controllers.Names() {
+		if known == name {
+			return true
+		}
+	}
+	return false
+}
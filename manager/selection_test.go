@@ -0,0 +1,51 @@
+package manager
+
+import (
+	"testing"
+
+	_ "github.com/luizalabs/sindico/controllers/watchdog"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{name: "defaults", cfg: Config{EnabledControllers: []string{"*"}}},
+		{
+			name: "controllers and subcontrollers",
+			cfg: Config{
+				EnabledControllers:  []string{"watchdog", "etcdbackup"},
+				DisabledControllers: []string{"watchdog/hpa"},
+				DryRunControllers:   []string{" watchdog/limits ", ""},
+			},
+		},
+		{
+			name:    "unknown enabled controller",
+			cfg:     Config{EnabledControllers: []string{"watchdgo"}},
+			wantErr: "invalid enabled controllers: unknown controller watchdgo",
+		},
+		{
+			name:    "unknown disabled subcontroller",
+			cfg:     Config{DisabledControllers: []string{"watchdog/pods"}},
+			wantErr: "invalid disabled controllers: unknown controller watchdog/pods",
+		},
+		{
+			name:    "unknown dry run controller",
+			cfg:     Config{DryRunControllers: []string{"backup"}},
+			wantErr: "invalid dry run controllers: unknown controller backup",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
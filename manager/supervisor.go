@@ -0,0 +1,97 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/controllers"
+	"github.com/luizalabs/sindico/notification"
+	"github.com/luizalabs/sindico/status"
+)
+
+type Notification interface {
+	Send(m *notification.Message, channel string) error
+}
+
+// supervisor runs a controller until ctx is done, restarting it with
+// exponential backoff whenever it panics or returns on its own.
+type supervisor struct {
+	channel    string
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+func newSupervisor(cfg *Config) *supervisor {
+	return &supervisor{
+		channel:    cfg.NotificationChannel,
+		backoff:    cfg.RestartBackoff,
+		maxBackoff: cfg.RestartBackoffMax,
+	}
+}
+
+func (s *supervisor) supervise(ctx context.Context, ctrl namedController) {
+	st := status.For(ctrl.cluster, ctrl.name)
+	backoff := s.backoff
+	for {
+		st.Started()
+		start := time.Now()
+		err := runSafe(ctrl, ctx.Done())
+		if ctx.Err() != nil {
+			st.Stopped(true)
+			return
+		}
+		st.Stopped(false)
+		if err == nil {
+			err = fmt.Errorf("stopped unexpectedly")
+		}
+		st.Fail(err)
+		// a controller that ran for a while is not crash looping
+		if time.Since(start) > s.maxBackoff {
+			backoff = s.backoff
+			st.Recovered()
+		}
+		st.Restarting(time.Now().Add(backoff))
+		ctrl.logger().Error("controller failed", "err", err, "restart_in", backoff)
+		s.notify(ctrl, err, backoff)
+		select {
+		case <-ctx.Done():
+			st.Stopped(true)
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
+
+func (s *supervisor) notify(ctrl namedController, err error, backoff time.Duration) {
+	m := &notification.Message{
+		Meta:  notification.Meta{Severity: notification.SeverityCritical},
+		Title: fmt.Sprintf("sindico controller %s failed", ctrl.name),
+		Text:  err.Error(),
+	}
+	m.AddField("Restarting in", backoff.String())
+	if err := ctrl.nt.Send(m, s.channel); err != nil {
+		ctrl.logger().Error("can't send message", "err", err)
+	}
+}
+
+func runSafe(ctrl namedController, stopCh <-chan struct{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ctrl.logger().Error("controller panicked", "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	ctrl.Run(stopCh)
+	return nil
+}
+
+func (c namedController) logger() log.Logger {
+	return controllers.Logger(c.cluster, "controller", c.name)
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const Namespace = "sindico"
+
+// Register adds c to the default prometheus registry. If an equal collector
+// was registered before, e.g. by another instance of the same controller,
+// the existing one is returned.
+func Register(c prometheus.Collector) prometheus.Collector {
+	if err := prometheus.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
+
+// ClusterLabels returns the constant labels of the metrics of a controller
+// watching cluster, none for the unnamed single cluster.
+func ClusterLabels(cluster string) prometheus.Labels {
+	if cluster == "" {
+		return nil
+	}
+	return prometheus.Labels{"cluster": cluster}
+}
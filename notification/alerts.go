@@ -0,0 +1,217 @@
+package notification
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/storage"
+	"github.com/pkg/errors"
+)
+
+type Storage interface {
+	UploadFile(path string, r io.ReadSeeker) error
+	ReadFile(path string) ([]byte, error)
+}
+
+// Alert is a condition that fired and didn't clear yet.
+type Alert struct {
+	Meta
+	Title   string    `json:"title,omitempty"`
+	Message string    `json:"message"`
+	Since   time.Time `json:"since"`
+	Sent    time.Time `json:"sent,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+}
+
+func (a *Alert) match(f *Meta) bool {
+	for _, key := range []string{"cluster", "severity", "namespace", "team"} {
+		if v := f.value(key); v != "" && v != a.value(key) {
+			return false
+		}
+	}
+	// a controller also matches its subcontrollers
+	if f.Controller != "" && f.Controller != a.Controller && !strings.HasPrefix(a.Controller, f.Controller+"/") {
+		return false
+	}
+	return (f.Object == "" || f.Object == a.Object) &&
+		(f.Condition == "" || f.Condition == a.Condition)
+}
+
+// alerts tracks the active alerts and suppresses their repeats: a message
+// is sent again only when it changes, and no sooner than window after the
+// previous one, or when it's unchanged for renotify. The state is kept in a
+// storage file so it survives restarts, saved every interval when changed.
+type alerts struct {
+	st       Storage
+	path     string
+	window   time.Duration
+	renotify time.Duration
+	interval time.Duration
+	logger   log.Logger
+	mu       sync.Mutex
+	loaded   bool
+	active   map[string]*Alert
+	dirty    map[string]bool
+}
+
+func newAlerts(st Storage, cfg *Config) *alerts {
+	return &alerts{
+		st:       st,
+		path:     cfg.StateFile,
+		window:   cfg.DedupWindow,
+		renotify: cfg.RenotifyInterval,
+		interval: cfg.StateInterval,
+		logger:   log.New("component", "notification"),
+		active:   make(map[string]*Alert),
+		dirty:    make(map[string]bool),
+	}
+}
+
+// alertKey identifies the alert a message is about, if it's about one.
+func alertKey(m *Meta) string {
+	if m.Condition == "" {
+		return ""
+	}
+	return strings.Join([]string{m.Cluster, m.Controller, m.Namespace, m.Object, m.Condition}, "/")
+}
+
+func hash(msg string) string {
+	sum := sha1.Sum([]byte(msg))
+	return hex.EncodeToString(sum[:])
+}
+
+// fire marks the alert as active and tells if m must be sent.
+func (a *alerts) fire(key string, m *Message, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.load()
+	al, found := a.active[key]
+	if !found {
+		a.active[key] = &Alert{Meta: m.Meta, Title: m.Title, Message: m.String(), Since: now}
+		a.dirty[key] = true
+		return true
+	}
+	al.Meta, al.Title, al.Message = m.Meta, m.Title, m.String()
+	if al.Sent.IsZero() {
+		return true
+	}
+	elapsed := now.Sub(al.Sent)
+	if al.Hash != hash(al.Message) {
+		return elapsed >= a.window
+	}
+	return a.renotify > 0 && elapsed >= a.renotify
+}
+
+func (a *alerts) sent(key string, m *Message, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if al, found := a.active[key]; found {
+		al.Sent, al.Hash = now, hash(m.String())
+		a.dirty[key] = true
+	}
+}
+
+func (a *alerts) resolve(key string) (*Alert, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.load()
+	al, found := a.active[key]
+	if found {
+		delete(a.active, key)
+		a.dirty[key] = true
+	}
+	return al, found
+}
+
+func (a *alerts) list(f *Meta) []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.load()
+	list := []Alert{}
+	for _, al := range a.active {
+		if al.match(f) {
+			list = append(list, *al)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Since.Before(list[j].Since) })
+	return list
+}
+
+// load reads the state once, alerts seen before loading are kept.
+func (a *alerts) load() {
+	if a.loaded || a.st == nil {
+		return
+	}
+	data, err := a.st.ReadFile(a.path)
+	if err == storage.ErrNotFound {
+		a.loaded = true
+		return
+	}
+	if err != nil {
+		a.logger.Error("failed to read alerts state", "err", err)
+		return
+	}
+	a.loaded = true
+	active := make(map[string]*Alert)
+	if err := json.Unmarshal(data, &active); err != nil {
+		a.logger.Error("invalid alerts state, starting over", "path", a.path, "err", err)
+		return
+	}
+	for k, al := range active {
+		if _, found := a.active[k]; !found && al.Condition != "" {
+			a.active[k] = al
+		}
+	}
+}
+
+// save uploads the state if it changed since the last save. The changes
+// of a failed upload are kept for the next one.
+func (a *alerts) save() {
+	a.mu.Lock()
+	if a.st == nil || len(a.dirty) == 0 {
+		a.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(a.active)
+	dirty := a.dirty
+	a.dirty = make(map[string]bool)
+	a.mu.Unlock()
+
+	if err == nil {
+		err = a.st.UploadFile(a.path, bytes.NewReader(data))
+	}
+	if err == nil {
+		return
+	}
+	keys := make([]string, 0, len(dirty))
+	a.mu.Lock()
+	for key := range dirty {
+		a.dirty[key] = true
+		keys = append(keys, key)
+	}
+	a.mu.Unlock()
+	sort.Strings(keys)
+	a.logger.Error("failed to save alerts state", "path", a.path, "alerts", strings.Join(keys, ","),
+		"err", errors.Wrap(err, "failed to save alerts state"))
+}
+
+// run saves the state every interval, and once more when stopCh is closed.
+func (a *alerts) run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			a.save()
+			return
+		case <-time.After(a.interval):
+		}
+		a.save()
+	}
+}
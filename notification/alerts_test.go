@@ -0,0 +1,258 @@
+package notification
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luizalabs/sindico/storage"
+)
+
+type memStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{files: make(map[string][]byte)}
+}
+
+func (s *memStorage) UploadFile(path string, r io.ReadSeeker) error {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.files[path] = buf.Bytes()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memStorage) ReadFile(path string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, found := s.files[path]
+	if !found {
+		return nil, storage.ErrNotFound
+	}
+	return data, nil
+}
+
+type fireStep struct {
+	after     time.Duration
+	text      string
+	delivered bool
+	want      bool
+}
+
+func TestAlertsFire(t *testing.T) {
+	tests := []struct {
+		name     string
+		renotify time.Duration
+		steps    []fireStep
+	}{
+		{
+			name:     "unchanged alert",
+			renotify: 4 * time.Hour,
+			steps: []fireStep{
+				{0, "3 pods", true, true},
+				{5 * time.Minute, "3 pods", true, false},
+				{3 * time.Hour, "3 pods", true, false},
+				{4 * time.Hour, "3 pods", true, true},
+				{4*time.Hour + 5*time.Minute, "3 pods", true, false},
+			},
+		},
+		{
+			name: "unchanged alert never renotified",
+			steps: []fireStep{
+				{0, "3 pods", true, true},
+				{48 * time.Hour, "3 pods", true, false},
+			},
+		},
+		{
+			name:     "changed alert",
+			renotify: 4 * time.Hour,
+			steps: []fireStep{
+				{0, "3 pods", true, true},
+				{5 * time.Minute, "4 pods", true, false},
+				{15 * time.Minute, "4 pods", true, true},
+				{20 * time.Minute, "4 pods", true, false},
+			},
+		},
+		{
+			name:     "undelivered alert",
+			renotify: 4 * time.Hour,
+			steps: []fireStep{
+				{0, "3 pods", false, true},
+				{time.Minute, "3 pods", false, true},
+				{2 * time.Minute, "3 pods", true, true},
+				{3 * time.Minute, "3 pods", true, false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAlerts(newMemStorage(), &Config{StateFile: "alerts.json", DedupWindow: 15 * time.Minute, RenotifyInterval: tt.renotify})
+			start := time.Now()
+			for i, step := range tt.steps {
+				m := &Message{Meta: Meta{Namespace: "ns", Condition: "CrashLoopBackOff"}, Text: step.text}
+				now := start.Add(step.after)
+				if got := a.fire("key", m, now); got != step.want {
+					t.Fatalf("step %d: got %v, want %v", i, got, step.want)
+				}
+				if step.want && step.delivered {
+					a.sent("key", m, now)
+				}
+			}
+		})
+	}
+}
+
+func TestAlertsState(t *testing.T) {
+	st := newMemStorage()
+	cfg := &Config{StateFile: "alerts.json", DedupWindow: 15 * time.Minute, RenotifyInterval: 4 * time.Hour}
+	m := &Message{Meta: Meta{Cluster: "prod", Controller: "kubewatch", Namespace: "ns", Condition: "CrashLoopBackOff"}, Text: "3 pods"}
+	key := alertKey(&m.Meta)
+	now := time.Now()
+
+	a := newAlerts(st, cfg)
+	if !a.fire(key, m, now) {
+		t.Fatal("expected a new alert to be sent")
+	}
+	a.sent(key, m, now)
+	if _, err := st.ReadFile(cfg.StateFile); err != storage.ErrNotFound {
+		t.Fatalf("got %v, want the state saved only on the next save", err)
+	}
+	a.save()
+
+	// a restart keeps the alerts and their dedup state
+	restarted := newAlerts(st, cfg)
+	if restarted.fire(key, m, now.Add(time.Minute)) {
+		t.Error("expected the alert to stay suppressed after a restart")
+	}
+	list := restarted.list(&Meta{Controller: "kubewatch"})
+	if len(list) != 1 || list[0].Namespace != "ns" || !list[0].Since.Equal(now) {
+		t.Fatalf("got %+v, want the alert fired at %s", list, now)
+	}
+	if got := restarted.list(&Meta{Controller: "watchdog"}); len(got) != 0 {
+		t.Errorf("got %+v, want no alerts of another controller", got)
+	}
+
+	al, found := restarted.resolve(key)
+	if !found || al.Namespace != "ns" {
+		t.Fatalf("got %+v, %v, want the resolved alert", al, found)
+	}
+	if _, found := restarted.resolve(key); found {
+		t.Error("expected the alert to be resolved only once")
+	}
+	restarted.save()
+	if !newAlerts(st, cfg).fire(key, m, now.Add(2*time.Minute)) {
+		t.Error("expected the alert to be sent again once resolved")
+	}
+}
+
+type failingStorage struct {
+	*memStorage
+	fail bool
+}
+
+func (s *failingStorage) UploadFile(path string, r io.ReadSeeker) error {
+	if s.fail {
+		return errors.New("unavailable")
+	}
+	return s.memStorage.UploadFile(path, r)
+}
+
+func TestAlertsSave(t *testing.T) {
+	st := &failingStorage{memStorage: newMemStorage(), fail: true}
+	cfg := &Config{StateFile: "alerts.json", DedupWindow: 15 * time.Minute}
+	m := &Message{Meta: Meta{Namespace: "ns", Condition: "NoFirewall"}, Text: "svc"}
+	key := alertKey(&m.Meta)
+
+	a := newAlerts(st, cfg)
+	a.fire(key, m, time.Now())
+	a.save()
+	if len(a.dirty) != 1 {
+		t.Fatalf("got dirty %v, want the alert kept for the next save", a.dirty)
+	}
+
+	st.fail = false
+	a.save()
+	if len(a.dirty) != 0 {
+		t.Errorf("got dirty %v, want none once saved", a.dirty)
+	}
+	if list := newAlerts(st, cfg).list(&Meta{}); len(list) != 1 {
+		t.Errorf("got %+v, want the saved alert", list)
+	}
+}
+
+func TestAlertsRun(t *testing.T) {
+	st := newMemStorage()
+	cfg := &Config{StateFile: "alerts.json", StateInterval: time.Hour}
+	m := &Message{Meta: Meta{Namespace: "ns", Condition: "NoFirewall"}, Text: "svc"}
+
+	a := newAlerts(st, cfg)
+	a.fire(alertKey(&m.Meta), m, time.Now())
+	stopCh := make(chan struct{})
+	close(stopCh)
+	a.run(stopCh)
+	if _, err := st.ReadFile(cfg.StateFile); err != nil {
+		t.Errorf("got %v, want the state saved on stop", err)
+	}
+}
+
+func TestClientFire(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewWriter(&buf)
+	c.alerts = newAlerts(newMemStorage(), &Config{DedupWindow: 15 * time.Minute, RenotifyInterval: 4 * time.Hour})
+	c.suppressed = newSuppressedMetric()
+	alert := &Message{Meta: Meta{Namespace: "ns", Condition: "NoFirewall"}, Text: "svc"}
+	tests := []struct {
+		name string
+		m    *Message
+		want bool
+	}{
+		{"new alert", alert, true},
+		{"repeated alert", alert, false},
+		{"plain message", &Message{Text: "hello"}, true},
+		{"plain message again", &Message{Text: "hello"}, true},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		fired, err := c.Fire(tt.m, "")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if fired != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, fired, tt.want)
+		}
+		if posted := buf.Len() > 0; posted != tt.want {
+			t.Errorf("%s: posted %v, want %v", tt.name, posted, tt.want)
+		}
+	}
+}
+
+func TestClientClear(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewWriter(&buf)
+	c.alerts = newAlerts(newMemStorage(), &Config{DedupWindow: 15 * time.Minute, RenotifyInterval: 4 * time.Hour})
+	c.suppressed = newSuppressedMetric()
+	m := &Message{Meta: Meta{Namespace: "ns", Object: "svc", Condition: "NoFirewall"}, Text: "svc"}
+	if _, err := c.Fire(m, ""); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	c.Clear(m.Meta)
+	if buf.Len() > 0 {
+		t.Errorf("got posted %q, want nothing", buf.String())
+	}
+	if alerts := c.Alerts(Meta{Condition: "NoFirewall"}); len(alerts) != 0 {
+		t.Errorf("got alerts %+v, want none", alerts)
+	}
+	if fired, _ := c.Fire(m, ""); !fired {
+		t.Error("got alert suppressed after clear, want fired")
+	}
+}
@@ -0,0 +1,62 @@
+package notification
+
+import (
+	log "github.com/inconshreveable/log15"
+)
+
+// Namespaces looks up the metadata of namespaces.
+type Namespaces interface {
+	GetLabelValue(namespace, label string) (string, error)
+	GetAnnotationValue(namespace, annotation string) (string, error)
+}
+
+// channels sends the messages about a namespace to the channel of the
+// namespace, given by an annotation or label, or else to the channel of
+// its team. The channel of the controller is the fallback and, with
+// copyDefault, gets a copy of every message.
+type channels struct {
+	key         string
+	teams       map[string]string
+	copyDefault bool
+}
+
+func newChannels(cfg *Config) (*channels, error) {
+	teams, err := mapping(cfg.TeamChannels)
+	if err != nil {
+		return nil, err
+	}
+	return &channels{key: cfg.ChannelKey, teams: teams, copyDefault: cfg.CopyToDefault}, nil
+}
+
+func (c *channels) of(m *Meta, channel string, ns Namespaces) []string {
+	if c == nil {
+		return []string{channel}
+	}
+	own := c.namespace(m.Namespace, ns)
+	if own == "" {
+		own = c.teams[m.Team]
+	}
+	switch {
+	case own == "" || own == channel:
+		return []string{channel}
+	case c.copyDefault:
+		return []string{own, channel}
+	}
+	return []string{own}
+}
+
+func (c *channels) namespace(name string, ns Namespaces) string {
+	if name == "" || ns == nil || c.key == "" {
+		return ""
+	}
+	if v, err := ns.GetAnnotationValue(name, c.key); err != nil {
+		log.Debug("failed to get namespace annotation", "ns", name, "err", err)
+	} else if v != "" {
+		return v
+	}
+	v, err := ns.GetLabelValue(name, c.key)
+	if err != nil {
+		log.Debug("failed to get namespace label", "ns", name, "err", err)
+	}
+	return v
+}
@@ -0,0 +1,188 @@
+package notification
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type fakeNamespaces struct {
+	labels      map[string]string
+	annotations map[string]string
+	err         error
+}
+
+func (f *fakeNamespaces) GetLabelValue(namespace, label string) (string, error) {
+	return f.labels[namespace+" "+label], nil
+}
+
+func (f *fakeNamespaces) GetAnnotationValue(namespace, annotation string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	return f.annotations[namespace+" "+annotation], nil
+}
+
+const channelKey = "sindico.io/notification-channel"
+
+func TestChannelsOf(t *testing.T) {
+	ns := &fakeNamespaces{
+		labels: map[string]string{
+			"search " + channelKey: "#search-label",
+			"cart " + channelKey:   "#cart-label",
+		},
+		annotations: map[string]string{
+			"cart " + channelKey: "#cart",
+			"misc " + channelKey: "#alerts",
+		},
+	}
+	teams := map[string]string{"payments": "#payments", "checkout": "#checkout"}
+	tests := []struct {
+		name     string
+		channels *channels
+		ns       Namespaces
+		meta     Meta
+		want     []string
+	}{
+		{name: "no lookup", meta: Meta{Namespace: "cart"}, want: []string{"#alerts"}},
+		{
+			name:     "namespace annotation",
+			channels: &channels{key: channelKey, teams: teams},
+			ns:       ns,
+			meta:     Meta{Namespace: "cart", Team: "checkout"},
+			want:     []string{"#cart"},
+		},
+		{
+			name:     "namespace label",
+			channels: &channels{key: channelKey},
+			ns:       ns,
+			meta:     Meta{Namespace: "search"},
+			want:     []string{"#search-label"},
+		},
+		{
+			name:     "label when the annotation fails",
+			channels: &channels{key: channelKey},
+			ns:       &fakeNamespaces{labels: ns.labels, err: errors.New("not synced")},
+			meta:     Meta{Namespace: "cart"},
+			want:     []string{"#cart-label"},
+		},
+		{
+			name:     "team channel",
+			channels: &channels{key: channelKey, teams: teams},
+			ns:       ns,
+			meta:     Meta{Namespace: "checkout-api", Team: "payments"},
+			want:     []string{"#payments"},
+		},
+		{
+			name:     "team channel without namespace",
+			channels: &channels{key: channelKey, teams: teams},
+			ns:       ns,
+			meta:     Meta{Team: "payments"},
+			want:     []string{"#payments"},
+		},
+		{
+			name:     "team channel without namespace lookup",
+			channels: &channels{teams: teams},
+			ns:       ns,
+			meta:     Meta{Namespace: "cart", Team: "payments"},
+			want:     []string{"#payments"},
+		},
+		{
+			name:     "unknown team",
+			channels: &channels{key: channelKey, teams: teams},
+			ns:       ns,
+			meta:     Meta{Namespace: "other", Team: "search"},
+			want:     []string{"#alerts"},
+		},
+		{
+			name:     "own channel is the default",
+			channels: &channels{key: channelKey, copyDefault: true},
+			ns:       ns,
+			meta:     Meta{Namespace: "misc"},
+			want:     []string{"#alerts"},
+		},
+		{
+			name:     "copy to the default channel",
+			channels: &channels{key: channelKey, copyDefault: true},
+			ns:       ns,
+			meta:     Meta{Namespace: "cart"},
+			want:     []string{"#cart", "#alerts"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.channels.of(&tt.meta, "#alerts", tt.ns); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientPostFanOut(t *testing.T) {
+	ns := &fakeNamespaces{annotations: map[string]string{"cart " + channelKey: "#cart"}}
+	tests := []struct {
+		name        string
+		routes      []string
+		copyDefault bool
+		meta        Meta
+		wantSlack   []string
+		wantWebhook []string
+	}{
+		{
+			name:      "namespace channel",
+			meta:      Meta{Namespace: "cart"},
+			wantSlack: []string{"#cart 1"},
+		},
+		{
+			name:        "namespace channel and default copied to every route",
+			routes:      []string{"severity=critical => webhook", "severity=critical => slack"},
+			copyDefault: true,
+			meta:        Meta{Namespace: "cart", Severity: SeverityCritical},
+			wantSlack:   []string{"#alerts 1", "#cart 1"},
+			wantWebhook: []string{"#alerts 1", "#cart 1"},
+		},
+		{
+			name:        "route with its own channel",
+			routes:      []string{"team=payments => webhook:#oncall"},
+			meta:        Meta{Team: "payments"},
+			wantWebhook: []string{"#oncall 1"},
+		},
+		{
+			name:      "team channel and route to the same target once",
+			routes:    []string{"team=payments => slack:#payments"},
+			meta:      Meta{Team: "payments"},
+			wantSlack: []string{"#payments 1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack, webhook := &fakePoster{}, &fakePoster{}
+			r := &router{def: "slack", backends: map[string]Poster{"slack": slack, "webhook": webhook}}
+			for _, s := range tt.routes {
+				rl, err := parseRule(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.rules = append(r.rules, rl)
+			}
+			c := &Client{
+				router:     r,
+				channels:   &channels{key: channelKey, teams: map[string]string{"payments": "#payments"}, copyDefault: tt.copyDefault},
+				namespaces: ns,
+				failures:   newFailuresMetric(),
+			}
+			if _, err := c.post(&Message{Meta: tt.meta, Text: "1"}, "#alerts"); err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(slack.posts)
+			sort.Strings(webhook.posts)
+			if !reflect.DeepEqual(slack.posts, tt.wantSlack) {
+				t.Errorf("got slack posts %q, want %q", slack.posts, tt.wantSlack)
+			}
+			if !reflect.DeepEqual(webhook.posts, tt.wantWebhook) {
+				t.Errorf("got webhook posts %q, want %q", webhook.posts, tt.wantWebhook)
+			}
+		})
+	}
+}
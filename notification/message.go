@@ -0,0 +1,125 @@
+package notification
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Field is a named value shown apart from the text of a message.
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Link struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// Message is rendered natively by each backend. Text may use slack
+// formatting, e.g. *bold*, which backends convert as needed.
+type Message struct {
+	Meta
+	Title    string  `json:"title,omitempty"`
+	Text     string  `json:"text,omitempty"`
+	Fields   []Field `json:"fields,omitempty"`
+	Links    []Link  `json:"links,omitempty"`
+	Resolved bool    `json:"resolved,omitempty"`
+}
+
+// AddField appends a field, empty values are skipped.
+func (m *Message) AddField(name, value string) *Message {
+	if value != "" {
+		m.Fields = append(m.Fields, Field{Name: name, Value: value})
+	}
+	return m
+}
+
+func (m *Message) AddLink(title, url string) *Message {
+	m.Links = append(m.Links, Link{Title: title, URL: url})
+	return m
+}
+
+func (m *Message) emoji() string {
+	if m.Resolved {
+		return ":white_check_mark:"
+	}
+	switch m.Severity {
+	case SeverityCritical:
+		return ":rotating_light:"
+	case SeverityWarning:
+		return ":warning:"
+	case SeverityInfo:
+		return ":information_source:"
+	}
+	return ""
+}
+
+func (m *Message) color() string {
+	if m.Resolved {
+		return "good"
+	}
+	switch m.Severity {
+	case SeverityCritical:
+		return "danger"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "#439FE0"
+}
+
+func (m *Message) title() string {
+	if m.Resolved && m.Title != "" {
+		return "Resolved: " + m.Title
+	}
+	return m.Title
+}
+
+// subject is the first line of the message, without formatting.
+func (m *Message) subject() string {
+	s := m.title()
+	if s == "" {
+		s = strings.SplitN(strings.TrimSpace(m.Text), "\n", 2)[0]
+		s = strings.TrimSpace(slackEmojiRe.ReplaceAllString(s, ""))
+		s = strings.Replace(s, "*", "", -1)
+	}
+	if m.Cluster != "" {
+		s = "[" + m.Cluster + "] " + s
+	}
+	return s
+}
+
+// structured tells if the message has more than text, messages built by
+// PostMessage don't.
+func (m *Message) structured() bool {
+	return m.Title != "" || len(m.Fields) > 0 || len(m.Links) > 0
+}
+
+// String renders the message as slack formatted text, used by the backends
+// without a richer format.
+func (m *Message) String() string {
+	lines := []string{}
+	first := m.title()
+	if first != "" {
+		first = "*" + first + "*"
+		if e := m.emoji(); e != "" {
+			first = e + " " + first
+		}
+	} else {
+		first = strings.TrimSpace(m.Text)
+	}
+	if m.Cluster != "" {
+		first = "[" + m.Cluster + "] " + first
+	}
+	lines = append(lines, first)
+	if m.Title != "" && m.Text != "" {
+		lines = append(lines, strings.TrimSpace(m.Text))
+	}
+	for _, f := range m.Fields {
+		lines = append(lines, fmt.Sprintf("*%s*: %s", f.Name, f.Value))
+	}
+	for _, l := range m.Links {
+		lines = append(lines, fmt.Sprintf("%s: %s", l.Title, l.URL))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,42 @@
+package notification
+
+import (
+	"github.com/luizalabs/sindico/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newFailuresMetric() *prometheus.CounterVec {
+	return metrics.Register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "notification",
+		Name:      "send_failures_total",
+		Help:      "Number of messages that failed to be sent by cluster, backend and channel.",
+	}, []string{"cluster", "backend", "channel"})).(*prometheus.CounterVec)
+}
+
+func newSuppressedMetric() *prometheus.CounterVec {
+	return metrics.Register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "notification",
+		Name:      "suppressed_total",
+		Help:      "Number of repeated alerts that were not sent by cluster and controller.",
+	}, []string{"cluster", "controller"})).(*prometheus.CounterVec)
+}
+
+func newOutboxMetric() *prometheus.GaugeVec {
+	return metrics.Register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "notification",
+		Name:      "outbox_messages",
+		Help:      "Number of messages waiting to be delivered by backend.",
+	}, []string{"backend"})).(*prometheus.GaugeVec)
+}
+
+func newDroppedMetric() *prometheus.CounterVec {
+	return metrics.Register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "notification",
+		Name:      "dropped_total",
+		Help:      "Number of messages dropped as the backend rejected them for good by cluster, backend and channel.",
+	}, []string{"cluster", "backend", "channel"})).(*prometheus.CounterVec)
+}
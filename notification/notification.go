@@ -1,19 +1,365 @@
 package notification
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
 type Config struct {
-	Avatar   string `split_words:"true"`
-	Token    string `split_words:"true"`
-	Username string `split_words:"true" default:"sindico"`
+	Backend          string        `split_words:"true" default:"slack"`
+	Avatar           string        `split_words:"true"`
+	Token            string        `split_words:"true"`
+	Username         string        `split_words:"true" default:"sindico"`
+	TeamsWebhookURL  string        `envconfig:"teams_webhook_url"`
+	TeamsChannels    []string      `split_words:"true"`
+	WebhookURL       string        `envconfig:"webhook_url"`
+	WebhookTemplate  string        `split_words:"true" default:"{\"channel\": {{json .Channel}}, \"text\": {{json .Message}}}"`
+	WebhookHeaders   []string      `split_words:"true"`
+	SMTPHost         string        `envconfig:"smtp_host"`
+	SMTPPort         int           `envconfig:"smtp_port" default:"587"`
+	SMTPTLS          string        `envconfig:"smtp_tls" default:"starttls"`
+	SMTPUsername     string        `envconfig:"smtp_username"`
+	SMTPPassword     string        `envconfig:"smtp_password"`
+	SMTPFrom         string        `envconfig:"smtp_from"`
+	SMTPRecipients   []string      `envconfig:"smtp_recipients"`
+	Timeout          time.Duration `split_words:"true" default:"10s"`
+	Routes           []string      `split_words:"true"`
+	DedupWindow      time.Duration `split_words:"true" default:"15m"`
+	RenotifyInterval time.Duration `split_words:"true" default:"4h"`
+	StateFile        string        `split_words:"true" default:"notification/alerts.json"`
+	StateInterval    time.Duration `split_words:"true" default:"30s"`
+	RetryAttempts    int           `split_words:"true" default:"3"`
+	RetryBackoff     time.Duration `split_words:"true" default:"1s"`
+	RetryBackoffMax  time.Duration `split_words:"true" default:"30s"`
+	OutboxFile       string        `split_words:"true"`
+	OutboxConfigMap  string        `split_words:"true"`
+	OutboxInterval   time.Duration `split_words:"true" default:"1m"`
+	OutboxMaxAge     time.Duration `split_words:"true" default:"24h"`
+	ChannelKey       string        `split_words:"true" default:"sindico.io/notification-channel"`
+	TeamChannels     []string      `split_words:"true"`
+	CopyToDefault    bool          `split_words:"true"`
 }
 
+func (c *Config) Validate() error {
+	if c.DedupWindow < 0 {
+		return fmt.Errorf("dedup window must be positive, got %s", c.DedupWindow)
+	}
+	if c.RenotifyInterval < 0 {
+		return fmt.Errorf("renotify interval must be positive, got %s", c.RenotifyInterval)
+	}
+	if c.StateInterval <= 0 {
+		return fmt.Errorf("state interval must be positive, got %s", c.StateInterval)
+	}
+	if c.RetryAttempts < 1 {
+		return fmt.Errorf("retry attempts must be at least 1, got %d", c.RetryAttempts)
+	}
+	if c.RetryBackoff <= 0 || c.RetryBackoffMax < c.RetryBackoff {
+		return fmt.Errorf("invalid retry backoff %s, max %s", c.RetryBackoff, c.RetryBackoffMax)
+	}
+	if c.OutboxInterval <= 0 {
+		return fmt.Errorf("outbox interval must be positive, got %s", c.OutboxInterval)
+	}
+	if c.OutboxFile != "" && c.OutboxConfigMap != "" {
+		return errors.New("set either an outbox file or config map, not both")
+	}
+	if c.OutboxConfigMap != "" {
+		if _, _, err := c.OutboxConfigMapName(); err != nil {
+			return err
+		}
+	}
+	if _, err := mapping(c.TeamChannels); err != nil {
+		return err
+	}
+	_, err := newRouter(c)
+	return err
+}
+
+// Poster is a notification backend.
 type Poster interface {
-	PostMessage(msg, channel string) error
+	Post(m *Message, channel string) error
+}
+
+// OutboxConfigMapName splits OutboxConfigMap in namespace and name.
+func (c *Config) OutboxConfigMapName() (string, string, error) {
+	parts := strings.Split(c.OutboxConfigMap, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid outbox config map %q, use namespace/name", c.OutboxConfigMap)
+	}
+	return parts[0], parts[1], nil
 }
 
 type Client struct {
-	Poster
+	router     *router
+	alerts     *alerts
+	retry      *retry
+	outbox     *outbox
+	channels   *channels
+	namespaces Namespaces
+	cluster    string
+	controller string
+	failures   *prometheus.CounterVec
+	suppressed *prometheus.CounterVec
+}
+
+// PostMessage sends a plain text message, routed by no more than the
+// cluster and controller of the client. It's kept for the controllers not
+// using Send.
+func (c *Client) PostMessage(msg, channel string) error {
+	return c.Send(&Message{Text: msg}, channel)
+}
+
+// Send sends m to every backend and channel routed by its meta. The cluster
+// and the controller default to the ones of the client. Messages about an
+// alert, i.e. with a condition, mark it as active and are deduplicated.
+func (c *Client) Send(m *Message, channel string) error {
+	_, err := c.Fire(m, channel)
+	return err
+}
+
+// Fire is Send telling if m was posted or suppressed as a duplicate, so
+// whatever else is done when an alert fires can be deduplicated with it.
+func (c *Client) Fire(m *Message, channel string) (bool, error) {
+	cp := *m
+	m = &cp
+	c.fill(&m.Meta)
+	key := alertKey(&m.Meta)
+	if key == "" || c.alerts == nil {
+		_, err := c.post(m, channel)
+		return true, err
+	}
+	now := time.Now()
+	if !c.alerts.fire(key, m, now) {
+		c.suppressed.WithLabelValues(m.Cluster, m.Controller).Inc()
+		return false, nil
+	}
+	delivered, err := c.post(m, channel)
+	// an alert delivered anywhere counts as sent, otherwise the working
+	// backends would get it again on every run
+	if delivered {
+		c.alerts.sent(key, m, now)
+	}
+	return true, err
+}
+
+// Resolve clears the alert of meta and, if it was notified, sends a
+// resolved message with how long it lasted.
+func (c *Client) Resolve(meta Meta, channel string) error {
+	c.fill(&meta)
+	key := alertKey(&meta)
+	if key == "" || c.alerts == nil {
+		return nil
+	}
+	a, found := c.alerts.resolve(key)
+	if !found || a.Sent.IsZero() {
+		return nil
+	}
+	m := &Message{Meta: a.Meta, Title: a.Title, Resolved: true}
+	if m.Title == "" {
+		m.Title = a.Condition
+	}
+	m.AddField("Namespace", a.Namespace).
+		AddField("Object", a.Object).
+		AddField("Lasted", time.Since(a.Since).Round(time.Second).String())
+	_, err := c.post(m, channel)
+	return err
+}
+
+// Clear drops the alert of meta without a resolved message, e.g. once its
+// check is turned off, as the condition may still hold.
+func (c *Client) Clear(meta Meta) {
+	c.fill(&meta)
+	if key := alertKey(&meta); key != "" && c.alerts != nil {
+		c.alerts.resolve(key)
+	}
+}
+
+// Routed tells if the messages of meta go anywhere else than channel of the
+// default backend, e.g. to the channel of their team.
+func (c *Client) Routed(meta Meta, channel string) bool {
+	c.fill(&meta)
+	def := target{c.router.def, channel}
+	for _, ch := range c.channels.of(&meta, channel, c.namespaces) {
+		for _, t := range c.router.route(&meta, ch) {
+			if t != def {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Alerts returns the active alerts matching the non empty fields of f,
+// oldest first. The cluster and the controller default to the ones of the
+// client.
+func (c *Client) Alerts(f Meta) []Alert {
+	if c.alerts == nil {
+		return []Alert{}
+	}
+	c.fill(&f)
+	return c.alerts.list(&f)
+}
+
+func (c *Client) fill(meta *Meta) {
+	if meta.Cluster == "" {
+		meta.Cluster = c.cluster
+	}
+	if meta.Controller == "" {
+		meta.Controller = c.controller
+	}
+}
+
+// post tells if m was delivered, or queued to be, to any target. Only
+// temporary failures are queued.
+func (c *Client) post(m *Message, channel string) (bool, error) {
+	var targets []target
+	seen := make(map[target]bool)
+	for _, ch := range c.channels.of(&m.Meta, channel, c.namespaces) {
+		for _, t := range c.router.route(&m.Meta, ch) {
+			if !seen[t] {
+				seen[t] = true
+				targets = append(targets, t)
+			}
+		}
+	}
+	delivered := false
+	var errs []string
+	for _, t := range targets {
+		p := c.router.backends[t.backend]
+		var err error
+		if c.retry != nil {
+			err = c.retry.post(p, m, t.channel)
+		} else {
+			err = p.Post(m, t.channel)
+		}
+		if err == nil {
+			delivered = true
+			continue
+		}
+		c.failures.WithLabelValues(m.Cluster, t.backend, t.channel).Inc()
+		switch {
+		case c.outbox == nil:
+		case !isTemporary(err):
+			// it would fail the same way from the outbox
+			c.outbox.drop(t.backend, t.channel, m, err)
+			err = fmt.Errorf("%v, dropped", err)
+		default:
+			c.outbox.push(t.backend, t.channel, m)
+			delivered = true
+			err = fmt.Errorf("%v, queued", err)
+		}
+		errs = append(errs, fmt.Sprintf("%s %s: %v", t.backend, t.channel, err))
+	}
+	if len(errs) > 0 {
+		return delivered, errors.New(strings.Join(errs, "; "))
+	}
+	return true, nil
+}
+
+// Run delivers the queued messages every outbox interval and saves the
+// changed alerts every state interval until stopCh is closed, which also
+// ends the waits between retries. The alerts are saved once more before it
+// returns. It must run on a single replica.
+func (c *Client) Run(stopCh <-chan struct{}) {
+	if c.retry != nil {
+		c.retry.stopOn(stopCh)
+	}
+	var wg sync.WaitGroup
+	if c.outbox != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.outbox.run(c.router, stopCh)
+		}()
+	}
+	if c.alerts != nil {
+		c.alerts.run(stopCh)
+	}
+	wg.Wait()
+}
+
+// Flush saves the changed alerts right away, e.g. once the controllers
+// stopped.
+func (c *Client) Flush() {
+	if c.alerts != nil {
+		c.alerts.save()
+	}
+}
+
+// WithCluster returns a copy of the client adding the name of the cluster
+// to every message.
+func (c *Client) WithCluster(cluster string) *Client {
+	cp := *c
+	cp.cluster = cluster
+	return &cp
+}
+
+// WithNamespaces returns a copy of the client looking up the channel of
+// the namespace of messages in ns, e.g. a k8s.Client.
+func (c *Client) WithNamespaces(ns Namespaces) *Client {
+	cp := *c
+	cp.namespaces = ns
+	return &cp
+}
+
+// WithController returns a copy of the client routing messages as sent by
+// the controller.
+func (c *Client) WithController(name string) *Client {
+	cp := *c
+	cp.controller = name
+	return &cp
+}
+
+func newPoster(backend string, cfg *Config) (Poster, error) {
+	switch backend {
+	case "slack":
+		return newSlack(cfg), nil
+	case "teams":
+		return newTeams(cfg)
+	case "webhook":
+		return newWebhook(cfg)
+	case "smtp":
+		return newSMTP(cfg)
+	}
+	return nil, fmt.Errorf("unknown notification backend %q, use slack, teams, webhook or smtp", backend)
+}
+
+// New returns a client posting to the default backend and to the backends
+// of the configured routes. The state of the alerts is kept in st and the
+// messages that couldn't be delivered in outbox, or only in memory if nil.
+func New(cfg *Config, st Storage, outbox OutboxStore) (*Client, error) {
+	r, err := newRouter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := newChannels(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		router:     r,
+		channels:   ch,
+		alerts:     newAlerts(st, cfg),
+		retry:      &retry{attempts: cfg.RetryAttempts, backoff: cfg.RetryBackoff, maxBackoff: cfg.RetryBackoffMax},
+		outbox:     newOutbox(outbox, cfg),
+		failures:   newFailuresMetric(),
+		suppressed: newSuppressedMetric(),
+	}, nil
 }
 
-func New(cfg *Config) *Client {
-	return &Client{newSlack(cfg)}
+// mapping parses entries like key=value.
+func mapping(entries []string) (map[string]string, error) {
+	m := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		i := strings.Index(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid entry %q, use key=value", entry)
+		}
+		m[strings.TrimSpace(entry[:i])] = strings.TrimSpace(entry[i+1:])
+	}
+	return m, nil
 }
@@ -0,0 +1,215 @@
+package notification
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OutboxStore persists the messages that couldn't be delivered. Load
+// returns no data when nothing was saved yet.
+type OutboxStore interface {
+	Load() ([]byte, error)
+	Save(data []byte) error
+}
+
+type fileStore struct {
+	path string
+}
+
+// NewFileStore returns a store keeping the outbox in a local file.
+func NewFileStore(path string) OutboxStore {
+	return &fileStore{path: path}
+}
+
+func (f *fileStore) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (f *fileStore) Save(data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), ".outbox")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+type outboxEntry struct {
+	Backend  string    `json:"backend"`
+	Channel  string    `json:"channel"`
+	Message  *Message  `json:"message"`
+	Queued   time.Time `json:"queued"`
+	Attempts int       `json:"attempts"`
+}
+
+// outbox keeps the messages a backend failed to take, in order for each
+// channel, until they are delivered, fail for good or are older than maxAge.
+type outbox struct {
+	store    OutboxStore
+	interval time.Duration
+	maxAge   time.Duration
+	depth    *prometheus.GaugeVec
+	dropped  *prometheus.CounterVec
+	logger   log.Logger
+	mu       sync.Mutex
+	loaded   bool
+	entries  []*outboxEntry
+}
+
+func newOutbox(store OutboxStore, cfg *Config) *outbox {
+	return &outbox{
+		store:    store,
+		interval: cfg.OutboxInterval,
+		maxAge:   cfg.OutboxMaxAge,
+		depth:    newOutboxMetric(),
+		dropped:  newDroppedMetric(),
+		logger:   log.New("component", "notification", "outbox", true),
+	}
+}
+
+func (o *outbox) push(backend, channel string, m *Message) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.load()
+	o.entries = append(o.entries, &outboxEntry{Backend: backend, Channel: channel, Message: m, Queued: time.Now()})
+	o.save()
+}
+
+// drop gives up on m, the backend rejected it for good.
+func (o *outbox) drop(backend, channel string, m *Message, err error) {
+	o.logger.Error("dropping message the backend rejected", "backend", backend, "channel", channel, "title", m.subject(), "err", err)
+	o.dropped.WithLabelValues(m.Cluster, backend, channel).Inc()
+}
+
+// flush tries to deliver every queued message once. A channel failing
+// keeps the rest of its messages queued, so they are delivered in order,
+// while the other channels of the backend go on.
+func (o *outbox) flush(r *router) {
+	o.mu.Lock()
+	o.load()
+	pending := append([]*outboxEntry(nil), o.entries...)
+	o.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	done := make(map[*outboxEntry]bool)
+	tried := make(map[*outboxEntry]bool)
+	down := make(map[string]bool)
+	for _, e := range pending {
+		p, found := r.backends[e.Backend]
+		target := e.Backend + " " + e.Channel
+		switch {
+		case time.Since(e.Queued) > o.maxAge:
+			o.logger.Warn("dropping expired message", "backend", e.Backend, "channel", e.Channel, "queued", e.Queued, "attempts", e.Attempts)
+			done[e] = true
+		case !found:
+			o.logger.Warn("dropping message of unknown backend", "backend", e.Backend, "channel", e.Channel)
+			done[e] = true
+		case down[target]:
+		default:
+			tried[e] = true
+			err := p.Post(e.Message, e.Channel)
+			if err != nil && !isTemporary(err) {
+				o.drop(e.Backend, e.Channel, e.Message, err)
+				done[e] = true
+				continue
+			}
+			if err != nil {
+				o.logger.Debug("backend still failing", "backend", e.Backend, "channel", e.Channel, "err", err)
+				down[target] = true
+				continue
+			}
+			o.logger.Info("queued message delivered", "backend", e.Backend, "channel", e.Channel, "queued", e.Queued)
+			done[e] = true
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	entries := o.entries[:0]
+	for _, e := range o.entries {
+		if tried[e] {
+			e.Attempts++
+		}
+		if !done[e] {
+			entries = append(entries, e)
+		}
+	}
+	o.entries = entries
+	o.save()
+}
+
+func (o *outbox) run(r *router, stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(o.interval):
+		}
+		o.flush(r)
+	}
+}
+
+func (o *outbox) load() {
+	if o.loaded {
+		return
+	}
+	o.loaded = true
+	if o.store == nil {
+		return
+	}
+	data, err := o.store.Load()
+	if err != nil {
+		o.logger.Error("failed to load outbox", "err", err)
+		return
+	}
+	if len(data) == 0 {
+		return
+	}
+	var entries []*outboxEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		o.logger.Error("invalid outbox, starting over", "err", err)
+		return
+	}
+	o.entries = append(entries, o.entries...)
+	o.updateDepth()
+}
+
+func (o *outbox) save() {
+	o.updateDepth()
+	if o.store == nil {
+		return
+	}
+	data, err := json.Marshal(o.entries)
+	if err == nil {
+		err = o.store.Save(data)
+	}
+	if err != nil {
+		o.logger.Error("failed to save outbox", "err", errors.Wrap(err, "outbox"))
+	}
+}
+
+func (o *outbox) updateDepth() {
+	o.depth.Reset()
+	for _, e := range o.entries {
+		o.depth.WithLabelValues(e.Backend).Inc()
+	}
+}
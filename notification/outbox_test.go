@@ -0,0 +1,171 @@
+package notification
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePoster fails the channels in errs and records every attempt.
+type fakePoster struct {
+	mu    sync.Mutex
+	errs  map[string]error
+	tried []string
+	posts []string
+}
+
+func (p *fakePoster) Post(m *Message, channel string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tried = append(p.tried, channel+" "+m.Text)
+	if err := p.errs[channel]; err != nil {
+		return err
+	}
+	p.posts = append(p.posts, channel+" "+m.Text)
+	return nil
+}
+
+type memOutboxStore struct {
+	data []byte
+}
+
+func (s *memOutboxStore) Load() ([]byte, error) {
+	return s.data, nil
+}
+
+func (s *memOutboxStore) Save(data []byte) error {
+	s.data = append([]byte(nil), data...)
+	return nil
+}
+
+type queued struct {
+	backend, channel, text string
+	age                    time.Duration
+}
+
+func TestOutboxFlush(t *testing.T) {
+	temp := temporary(errors.New("503"))
+	perm := errors.New("channel_not_found")
+	tests := []struct {
+		name    string
+		queued  []queued
+		errs    map[string]error
+		tried   []string
+		posts   []string
+		pending []string
+	}{
+		{
+			name:   "delivered in order",
+			queued: []queued{{"slack", "#a", "1", 0}, {"slack", "#b", "2", 0}, {"slack", "#a", "3", 0}},
+			tried:  []string{"#a 1", "#b 2", "#a 3"},
+			posts:  []string{"#a 1", "#b 2", "#a 3"},
+		},
+		{
+			name:    "failing channel keeps its order without blocking the others",
+			queued:  []queued{{"slack", "#a", "1", 0}, {"slack", "#b", "2", 0}, {"slack", "#a", "3", 0}},
+			errs:    map[string]error{"#a": temp},
+			tried:   []string{"#a 1", "#b 2"},
+			posts:   []string{"#b 2"},
+			pending: []string{"1", "3"},
+		},
+		{
+			name:   "rejected messages are dropped",
+			queued: []queued{{"slack", "#a", "1", 0}, {"slack", "#a", "2", 0}, {"slack", "#b", "3", 0}},
+			errs:   map[string]error{"#a": perm},
+			tried:  []string{"#a 1", "#a 2", "#b 3"},
+			posts:  []string{"#b 3"},
+		},
+		{
+			name:    "expired messages are dropped",
+			queued:  []queued{{"slack", "#a", "1", 25 * time.Hour}, {"slack", "#a", "2", time.Hour}},
+			errs:    map[string]error{"#a": temp},
+			tried:   []string{"#a 2"},
+			pending: []string{"2"},
+		},
+		{
+			name:   "messages of unknown backends are dropped",
+			queued: []queued{{"teams", "#a", "1", 0}, {"slack", "#a", "2", 0}},
+			tried:  []string{"#a 2"},
+			posts:  []string{"#a 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakePoster{errs: tt.errs}
+			o := newOutbox(nil, &Config{OutboxInterval: time.Minute, OutboxMaxAge: 24 * time.Hour})
+			for _, q := range tt.queued {
+				o.push(q.backend, q.channel, &Message{Text: q.text})
+				o.entries[len(o.entries)-1].Queued = time.Now().Add(-q.age)
+			}
+			o.flush(&router{backends: map[string]Poster{"slack": p}})
+			if !reflect.DeepEqual(p.tried, tt.tried) {
+				t.Errorf("tried %q, want %q", p.tried, tt.tried)
+			}
+			if !reflect.DeepEqual(p.posts, tt.posts) {
+				t.Errorf("posted %q, want %q", p.posts, tt.posts)
+			}
+			var pending []string
+			for _, e := range o.entries {
+				pending = append(pending, e.Message.Text)
+			}
+			if !reflect.DeepEqual(pending, tt.pending) {
+				t.Errorf("pending %q, want %q", pending, tt.pending)
+			}
+		})
+	}
+}
+
+func TestOutboxStore(t *testing.T) {
+	cfg := &Config{OutboxInterval: time.Minute, OutboxMaxAge: 24 * time.Hour}
+	store := &memOutboxStore{}
+	newOutbox(store, cfg).push("slack", "#a", &Message{Text: "1"})
+
+	// a restart delivers what was queued before it
+	p := &fakePoster{}
+	o := newOutbox(store, cfg)
+	o.flush(&router{backends: map[string]Poster{"slack": p}})
+	if want := []string{"#a 1"}; !reflect.DeepEqual(p.posts, want) {
+		t.Errorf("posted %q, want %q", p.posts, want)
+	}
+	if string(store.data) != "[]" {
+		t.Errorf("got stored outbox %s, want it empty", store.data)
+	}
+}
+
+func TestClientPostQueue(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		delivered bool
+		queued    int
+		errSuffix string
+	}{
+		{"sent", nil, true, 0, ""},
+		{"temporary failure queued", temporary(errors.New("503")), true, 1, "queued"},
+		{"rate limited queued", &rateLimitedError{after: time.Hour}, true, 1, "queued"},
+		{"rejected message dropped", errors.New("channel_not_found"), false, 0, "dropped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakePoster{errs: map[string]error{"#a": tt.err}}
+			c := &Client{
+				router:   &router{def: "slack", backends: map[string]Poster{"slack": p}},
+				outbox:   newOutbox(nil, &Config{OutboxInterval: time.Minute, OutboxMaxAge: time.Hour}),
+				failures: newFailuresMetric(),
+			}
+			delivered, err := c.post(&Message{Text: "1"}, "#a")
+			if delivered != tt.delivered {
+				t.Errorf("got delivered %v, want %v", delivered, tt.delivered)
+			}
+			if (err == nil) != (tt.errSuffix == "") || err != nil && !strings.HasSuffix(err.Error(), tt.errSuffix) {
+				t.Errorf("got error %v, want it to end with %q", err, tt.errSuffix)
+			}
+			if len(c.outbox.entries) != tt.queued {
+				t.Errorf("got %d queued messages, want %d", len(c.outbox.entries), tt.queued)
+			}
+		})
+	}
+}
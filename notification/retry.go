@@ -0,0 +1,110 @@
+package notification
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// rateLimitedError is returned by backends asked to slow down, e.g. by a
+// 429 response with a Retry-After header.
+type rateLimitedError struct {
+	after time.Duration
+}
+
+func (e *rateLimitedError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %s", e.after)
+}
+
+// rateLimited returns a rateLimitedError for 429 responses.
+func rateLimited(resp *http.Response) error {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	after := time.Second
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		after = time.Duration(secs) * time.Second
+	}
+	return &rateLimitedError{after: after}
+}
+
+// temporaryError is a failure that may go away by itself, e.g. a network
+// error or a 5xx response. Backends return any other error for failures
+// that would happen again, e.g. an unknown channel or a 4xx response.
+type temporaryError struct {
+	err error
+}
+
+func (e *temporaryError) Error() string {
+	return e.err.Error()
+}
+
+func temporary(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &temporaryError{err: err}
+}
+
+// isTemporary tells if sending again may succeed.
+func isTemporary(err error) bool {
+	for _, e := range []error{err, errors.Cause(err)} {
+		switch e.(type) {
+		case *temporaryError, *rateLimitedError:
+			return true
+		}
+	}
+	return false
+}
+
+type retry struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	mu         sync.Mutex
+	stop       <-chan struct{}
+}
+
+// stopOn makes the waits between attempts end once stopCh is closed.
+func (r *retry) stopOn(stopCh <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stop = stopCh
+}
+
+// post tries to send m up to attempts times with exponential backoff, or
+// waiting as long as the backend asked to. Only temporary errors are
+// retried, waits longer than maxBackoff and the ones cut short by stop are
+// left to the outbox.
+func (r *retry) post(p Poster, m *Message, channel string) error {
+	backoff := r.backoff
+	for i := 1; ; i++ {
+		err := p.Post(m, channel)
+		if err == nil || i >= r.attempts || !isTemporary(err) {
+			return err
+		}
+		wait := backoff
+		if rl, ok := errors.Cause(err).(*rateLimitedError); ok {
+			wait = rl.after
+		}
+		if wait > r.maxBackoff {
+			return err
+		}
+		r.mu.Lock()
+		stop := r.stop
+		r.mu.Unlock()
+		select {
+		case <-stop:
+			return err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package notification
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestRateLimited(t *testing.T) {
+	tests := []struct {
+		status     int
+		retryAfter string
+		want       time.Duration
+	}{
+		{http.StatusOK, "", 0},
+		{http.StatusServiceUnavailable, "10", 0},
+		{http.StatusTooManyRequests, "", time.Second},
+		{http.StatusTooManyRequests, "30", 30 * time.Second},
+		{http.StatusTooManyRequests, "Wed, 21 Oct 2015 07:28:00 GMT", time.Second},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		resp.Header.Set("Retry-After", tt.retryAfter)
+		var got time.Duration
+		if rl, ok := rateLimited(resp).(*rateLimitedError); ok {
+			got = rl.after
+		}
+		if got != tt.want {
+			t.Errorf("%d %q: got %s, want %s", tt.status, tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+func TestPostJSONErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		wantErr   bool
+		temporary bool
+	}{
+		{http.StatusOK, false, false},
+		{http.StatusBadRequest, true, false},
+		{http.StatusNotFound, true, false},
+		{http.StatusTooManyRequests, true, true},
+		{http.StatusInternalServerError, true, true},
+		{http.StatusBadGateway, true, true},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+		err := postJSON(srv.Client(), srv.URL, []byte("{}"), nil)
+		srv.Close()
+		if (err != nil) != tt.wantErr || isTemporary(err) != tt.temporary {
+			t.Errorf("%d: got %v (temporary %v), want error %v, temporary %v", tt.status, err, isTemporary(err), tt.wantErr, tt.temporary)
+		}
+	}
+	if err := postJSON(http.DefaultClient, "http://127.0.0.1:1", []byte("{}"), nil); !isTemporary(err) {
+		t.Errorf("got %v, want a temporary network error", err)
+	}
+}
+
+// seqPoster returns the errors in turn, nil once they're over.
+type seqPoster struct {
+	errs  []error
+	calls int
+}
+
+func (p *seqPoster) Post(m *Message, channel string) error {
+	p.calls++
+	if p.calls <= len(p.errs) {
+		return p.errs[p.calls-1]
+	}
+	return nil
+}
+
+func TestRetryPost(t *testing.T) {
+	temp := temporary(errors.New("503"))
+	tests := []struct {
+		name    string
+		errs    []error
+		calls   int
+		wantErr bool
+	}{
+		{"sent at once", nil, 1, false},
+		{"temporary failure retried", []error{temp, temp}, 3, false},
+		{"wrapped temporary failure retried", []error{pkgerrors.Wrap(temp, "slack")}, 2, false},
+		{"attempts exhausted", []error{temp, temp, temp, temp}, 3, true},
+		{"rejected message not retried", []error{errors.New("channel_not_found")}, 1, true},
+		{"short retry after waited", []error{&rateLimitedError{after: time.Millisecond}}, 2, false},
+		{"long retry after left to the outbox", []error{&rateLimitedError{after: time.Hour}}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &retry{attempts: 3, backoff: time.Millisecond, maxBackoff: 10 * time.Millisecond}
+			p := &seqPoster{errs: tt.errs}
+			err := r.post(p, &Message{Text: "1"}, "#a")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got %v, want error %v", err, tt.wantErr)
+			}
+			if p.calls != tt.calls {
+				t.Errorf("got %d calls, want %d", p.calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRetryStop(t *testing.T) {
+	r := &retry{attempts: 3, backoff: time.Hour, maxBackoff: time.Hour}
+	stopCh := make(chan struct{})
+	r.stopOn(stopCh)
+	close(stopCh)
+	p := &seqPoster{errs: []error{temporary(errors.New("503"))}}
+	done := make(chan error)
+	go func() { done <- r.post(p, &Message{Text: "1"}, "#a") }()
+	select {
+	case err := <-done:
+		if err == nil || p.calls != 1 {
+			t.Errorf("got %v after %d calls, want the first error", err, p.calls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry didn't stop")
+	}
+}
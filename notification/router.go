@@ -0,0 +1,160 @@
+package notification
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Meta describes what a message is about, so it can be routed. Messages
+// about an alert set its Condition, e.g. CrashLoopBackOff, and Object when
+// it's about a single object of the namespace.
+type Meta struct {
+	Cluster    string `json:"cluster,omitempty"`
+	Controller string `json:"controller,omitempty"`
+	Severity   string `json:"severity,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Team       string `json:"team,omitempty"`
+	Object     string `json:"object,omitempty"`
+	Condition  string `json:"condition,omitempty"`
+}
+
+func (m *Meta) value(key string) string {
+	switch key {
+	case "cluster":
+		return m.Cluster
+	case "controller":
+		return m.Controller
+	case "severity":
+		return m.Severity
+	case "namespace":
+		return m.Namespace
+	case "team":
+		return m.Team
+	}
+	return ""
+}
+
+// rule sends the messages matching all its conditions to a backend, e.g.
+// "controller=etcdbackup severity=critical => webhook" or
+// "team=payments => slack:#payments-alerts". Values are glob patterns and a
+// controller also matches its subcontrollers. Without a channel the one
+// given by the controller is kept.
+type rule struct {
+	conds   map[string]string
+	backend string
+	channel string
+}
+
+func parseRule(s string) (*rule, error) {
+	parts := strings.SplitN(s, "=>", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid route %q, use [key=value ...] => backend[:channel]", s)
+	}
+	r := &rule{conds: make(map[string]string)}
+	for _, cond := range strings.Fields(parts[0]) {
+		kv := strings.SplitN(cond, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid condition %q of route %q", cond, s)
+		}
+		switch kv[0] {
+		case "cluster", "controller", "severity", "namespace", "team":
+		default:
+			return nil, fmt.Errorf("unknown key %q of route %q, use one of cluster, controller, severity, namespace or team", kv[0], s)
+		}
+		if _, err := path.Match(kv[1], ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q of route %q", kv[1], s)
+		}
+		r.conds[kv[0]] = kv[1]
+	}
+	target := strings.TrimSpace(parts[1])
+	if i := strings.Index(target, ":"); i >= 0 {
+		r.backend, r.channel = target[:i], target[i+1:]
+	} else {
+		r.backend = target
+	}
+	return r, nil
+}
+
+func (r *rule) matches(m *Meta) bool {
+	for key, pattern := range r.conds {
+		value := m.value(key)
+		if ok, _ := path.Match(pattern, value); ok {
+			continue
+		}
+		if key == "controller" {
+			if ok, _ := path.Match(pattern, strings.SplitN(value, "/", 2)[0]); ok {
+				continue
+			}
+		}
+		return false
+	}
+	return true
+}
+
+type target struct {
+	backend string
+	channel string
+}
+
+// router fans messages out to the targets of every matching rule. Messages
+// matching no rule go to the default backend.
+type router struct {
+	def      string
+	backends map[string]Poster
+	rules    []*rule
+}
+
+func (r *router) route(m *Meta, channel string) []target {
+	targets := []target{}
+	seen := make(map[target]bool)
+	for _, rl := range r.rules {
+		if !rl.matches(m) {
+			continue
+		}
+		t := target{rl.backend, rl.channel}
+		if t.channel == "" {
+			t.channel = channel
+		}
+		if !seen[t] {
+			seen[t] = true
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		targets = append(targets, target{r.def, channel})
+	}
+	return targets
+}
+
+func newRouter(cfg *Config) (*router, error) {
+	r := &router{def: cfg.Backend, backends: make(map[string]Poster)}
+	for _, s := range cfg.Routes {
+		rl, err := parseRule(s)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, rl)
+	}
+	names := []string{r.def}
+	for _, rl := range r.rules {
+		names = append(names, rl.backend)
+	}
+	for _, name := range names {
+		if _, found := r.backends[name]; found {
+			continue
+		}
+		p, err := newPoster(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		r.backends[name] = p
+	}
+	return r, nil
+}
@@ -0,0 +1,158 @@
+package notification
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		route string
+		want  *rule
+		err   string
+	}{
+		{
+			route: "controller=etcdbackup severity=critical => webhook",
+			want:  &rule{conds: map[string]string{"controller": "etcdbackup", "severity": "critical"}, backend: "webhook"},
+		},
+		{
+			route: "team=payments => slack:#payments-alerts",
+			want:  &rule{conds: map[string]string{"team": "payments"}, backend: "slack", channel: "#payments-alerts"},
+		},
+		{
+			route: "=> teams",
+			want:  &rule{conds: map[string]string{}, backend: "teams"},
+		},
+		{route: "team=payments slack", err: "invalid route"},
+		{route: "team => slack", err: "invalid condition"},
+		{route: "pod=x => slack", err: "unknown key"},
+		{route: "namespace=[ => slack", err: "invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			got, err := parseRule(tt.route)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	meta := &Meta{Cluster: "prod-us", Controller: "watchdog/hpa", Severity: SeverityWarning, Namespace: "payments-api", Team: "payments"}
+	tests := []struct {
+		route string
+		want  bool
+	}{
+		{"=> slack", true},
+		{"cluster=prod-* => slack", true},
+		{"cluster=staging-* => slack", false},
+		{"controller=watchdog => slack", true},
+		{"controller=watchdog/hpa => slack", true},
+		{"controller=watchdog/limits => slack", false},
+		{"controller=watch => slack", false},
+		{"namespace=payments-* team=payments => slack", true},
+		{"namespace=payments-* team=search => slack", false},
+		{"severity=critical => slack", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			rl, err := parseRule(tt.route)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rl.matches(meta); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []string
+		meta   Meta
+		want   []target
+	}{
+		{
+			name: "no routes",
+			meta: Meta{Controller: "kubewatch"},
+			want: []target{{"slack", "#alerts"}},
+		},
+		{
+			name:   "no matching route",
+			routes: []string{"controller=etcdbackup => webhook"},
+			meta:   Meta{Controller: "kubewatch"},
+			want:   []target{{"slack", "#alerts"}},
+		},
+		{
+			name:   "every matching route",
+			routes: []string{"severity=critical => webhook", "team=payments => slack:#payments", "=> slack"},
+			meta:   Meta{Severity: SeverityCritical, Team: "payments"},
+			want:   []target{{"webhook", "#alerts"}, {"slack", "#payments"}, {"slack", "#alerts"}},
+		},
+		{
+			name:   "duplicated targets",
+			routes: []string{"severity=critical => webhook", "team=payments => webhook"},
+			meta:   Meta{Severity: SeverityCritical, Team: "payments"},
+			want:   []target{{"webhook", "#alerts"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &router{def: "slack"}
+			for _, s := range tt.routes {
+				rl, err := parseRule(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.rules = append(r.rules, rl)
+			}
+			if got := r.route(&tt.meta, "#alerts"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientRouted(t *testing.T) {
+	tests := []struct {
+		name     string
+		routes   []string
+		channels *channels
+		want     bool
+	}{
+		{name: "default channel", want: false},
+		{name: "team channel", channels: &channels{teams: map[string]string{"payments": "#payments"}}, want: true},
+		{name: "other team channel", channels: &channels{teams: map[string]string{"search": "#search"}}, want: false},
+		{name: "team route", routes: []string{"team=payments => slack:#payments"}, want: true},
+		{name: "route to the default target", routes: []string{"team=payments => slack"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &router{def: "slack"}
+			for _, s := range tt.routes {
+				rl, err := parseRule(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.rules = append(r.rules, rl)
+			}
+			c := &Client{router: r, channels: tt.channels}
+			if got := c.Routed(Meta{Team: "payments"}, "#alerts"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,26 +1,148 @@
 package notification
 
 import (
-	"github.com/drgarcia1986/slacker/slack"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
 )
 
-type SlackClient interface {
-	PostMessage(channel, username, avatar, msg string) error
+const slackURL = "https://slack.com/api/chat.postMessage"
+
+var slackAvatarRe = regexp.MustCompile("^:[^:]+:$")
+
+// slackTemporaryErrors are the errors of the slack api worth retrying, the
+// others, e.g. channel_not_found or invalid_auth, would happen again.
+var slackTemporaryErrors = map[string]bool{
+	"fatal_error":         true,
+	"internal_error":      true,
+	"ratelimited":         true,
+	"request_timeout":     true,
+	"service_unavailable": true,
 }
 
+// Slack posts plain messages as text and structured ones as attachments.
 type Slack struct {
-	client   SlackClient
+	client   *http.Client
+	url      string
+	token    string
 	username string
 	avatar   string
 }
 
-func (s *Slack) PostMessage(msg, channel string) error {
-	return s.client.PostMessage(channel, s.username, s.avatar, msg)
+type slackAttachment struct {
+	Fallback string       `json:"fallback"`
+	Color    string       `json:"color,omitempty"`
+	Title    string       `json:"title,omitempty"`
+	Text     string       `json:"text,omitempty"`
+	Fields   []slackField `json:"fields,omitempty"`
+	Footer   string       `json:"footer,omitempty"`
+	MrkdwnIn []string     `json:"mrkdwn_in"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type slackResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+func (s *Slack) Post(m *Message, channel string) error {
+	form := url.Values{
+		"token":    {s.token},
+		"channel":  {channel},
+		"username": {s.username},
+		"as_user":  {"false"},
+	}
+	if s.avatar != "" {
+		if slackAvatarRe.MatchString(s.avatar) {
+			form.Set("icon_emoji", s.avatar)
+		} else {
+			form.Set("icon_url", s.avatar)
+		}
+	}
+	if m.structured() {
+		attachments, err := json.Marshal([]slackAttachment{slackAttach(m)})
+		if err != nil {
+			return err
+		}
+		form.Set("attachments", string(attachments))
+	} else {
+		form.Set("text", m.String())
+		form.Set("parse", "full")
+	}
+	resp, err := s.client.PostForm(s.url, form)
+	if err != nil {
+		return temporary(errors.Wrap(err, "request failed"))
+	}
+	defer resp.Body.Close()
+	if err := rateLimited(resp); err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return temporary(err)
+	}
+	var r slackResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		err := fmt.Errorf("unexpected slack response %d: %s", resp.StatusCode, body)
+		if resp.StatusCode >= 500 {
+			return temporary(err)
+		}
+		return err
+	}
+	if !r.Ok {
+		if slackTemporaryErrors[r.Error] {
+			return temporary(errors.New(r.Error))
+		}
+		return errors.New(r.Error)
+	}
+	return nil
+}
+
+func slackAttach(m *Message) slackAttachment {
+	text := []string{}
+	if m.Text != "" {
+		text = append(text, m.Text)
+	}
+	for _, l := range m.Links {
+		text = append(text, fmt.Sprintf("<%s|%s>", l.URL, l.Title))
+	}
+	fields := make([]slackField, len(m.Fields))
+	for i, f := range m.Fields {
+		fields[i] = slackField{Title: f.Name, Value: f.Value, Short: len(f.Value) < 40}
+	}
+	footer := []string{"sindico"}
+	for _, s := range []string{m.Cluster, m.Controller} {
+		if s != "" {
+			footer = append(footer, s)
+		}
+	}
+	return slackAttachment{
+		Fallback: m.subject(),
+		Color:    m.color(),
+		Title:    m.title(),
+		Text:     strings.Join(text, "\n"),
+		Fields:   fields,
+		Footer:   strings.Join(footer, " | "),
+		MrkdwnIn: []string{"text", "fields"},
+	}
 }
 
 func newSlack(cfg *Config) *Slack {
 	return &Slack{
-		client:   slack.New(cfg.Token),
+		client:   &http.Client{Timeout: cfg.Timeout},
+		url:      slackURL,
+		token:    cfg.Token,
 		username: cfg.Username,
 		avatar:   cfg.Avatar,
 	}
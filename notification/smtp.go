@@ -0,0 +1,238 @@
+package notification
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/pkg/errors"
+)
+
+var slackItalicRe = regexp.MustCompile(`\b_([^_\n]+)_\b`)
+
+// SMTP emails messages to the recipients configured for the channel, as
+// plain text and html.
+type SMTP struct {
+	addr       string
+	host       string
+	tlsMode    string
+	auth       smtp.Auth
+	from       string
+	recipients map[string][]string
+	timeout    time.Duration
+}
+
+func (s *SMTP) Post(m *Message, channel string) error {
+	to, found := s.recipients[channel]
+	if !found {
+		to = s.recipients["*"]
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("no email recipients for channel %s", channel)
+	}
+	data, err := s.message(m, to)
+	if err != nil {
+		return err
+	}
+	return smtpError(s.send(to, data))
+}
+
+// smtpError tells the 5xx replies, rejected for good, apart from the
+// failures worth retrying.
+func smtpError(err error) error {
+	if tp, ok := errors.Cause(err).(*textproto.Error); ok && tp.Code >= 500 {
+		return err
+	}
+	return temporary(err)
+}
+
+func (s *SMTP) send(to []string, data []byte) error {
+	c, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if s.auth != nil {
+		if err := c.Auth(s.auth); err != nil {
+			return errors.Wrap(err, "smtp auth failed")
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	// the message was accepted, failing now would have it sent again
+	if err := c.Quit(); err != nil {
+		log.Warn("smtp quit failed", "component", "notification", "err", err)
+	}
+	return nil
+}
+
+func (s *SMTP) dial() (*smtp.Client, error) {
+	dialer := &net.Dialer{Timeout: s.timeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.tlsMode == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{ServerName: s.host})
+	} else {
+		conn, err = dialer.Dial("tcp", s.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("smtp connection failed: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(s.timeout))
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if s.tlsMode == "starttls" {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("smtp starttls failed: %v", err)
+		}
+	}
+	return c, nil
+}
+
+func (s *SMTP) message(m *Message, to []string) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	text := slackEmojiRe.ReplaceAllString(strings.TrimSpace(m.String()), "")
+	parts := []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", messageHTML(m, text)},
+	}
+	for _, p := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		w.Write([]byte(p.content))
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	subject := m.subject()
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	b.Write(body.Bytes())
+	return b.Bytes(), nil
+}
+
+// messageHTML renders structured messages as a heading, the text and a table
+// of fields, and the others from their text.
+func messageHTML(m *Message, text string) string {
+	if !m.structured() {
+		return slackToHTML(text)
+	}
+	var b bytes.Buffer
+	b.WriteString("<html><body>\n")
+	fmt.Fprintf(&b, "<h3>%s</h3>\n", html.EscapeString(m.subject()))
+	if m.Text != "" {
+		fmt.Fprintf(&b, "<p>%s</p>\n", slackInlineHTML(slackEmojiRe.ReplaceAllString(strings.TrimSpace(m.Text), "")))
+	}
+	if len(m.Fields) > 0 {
+		b.WriteString("<table>\n")
+		for _, f := range m.Fields {
+			fmt.Fprintf(&b, "<tr><th align=\"left\">%s</th><td>%s</td></tr>\n", html.EscapeString(f.Name), slackInlineHTML(f.Value))
+		}
+		b.WriteString("</table>\n")
+	}
+	for _, l := range m.Links {
+		fmt.Fprintf(&b, "<p><a href=\"%s\">%s</a></p>\n", html.EscapeString(l.URL), html.EscapeString(l.Title))
+	}
+	b.WriteString("</body></html>")
+	return b.String()
+}
+
+func slackInlineHTML(s string) string {
+	s = html.EscapeString(s)
+	s = slackBoldRe.ReplaceAllString(s, "<b>$1</b>")
+	s = slackItalicRe.ReplaceAllString(s, "<i>$1</i>")
+	return strings.Replace(s, "\n", "<br>\n", -1)
+}
+
+// slackToHTML converts slack bold and italic formatting to html.
+func slackToHTML(msg string) string {
+	return "<html><body>" + slackInlineHTML(msg) + "</body></html>"
+}
+
+// recipients parses entries like #alerts=a@example.com;b@example.com.
+func recipients(entries []string) (map[string][]string, error) {
+	m, err := mapping(entries)
+	if err != nil {
+		return nil, err
+	}
+	rcpts := make(map[string][]string, len(m))
+	for channel, addrs := range m {
+		for _, addr := range strings.Split(addrs, ";") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				rcpts[channel] = append(rcpts[channel], addr)
+			}
+		}
+	}
+	return rcpts, nil
+}
+
+func newSMTP(cfg *Config) (*SMTP, error) {
+	if cfg.SMTPHost == "" || cfg.SMTPFrom == "" {
+		return nil, errors.New("smtp backend needs a host and a from address")
+	}
+	switch cfg.SMTPTLS {
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("invalid smtp tls mode %q, use starttls, tls or none", cfg.SMTPTLS)
+	}
+	rcpts, err := recipients(cfg.SMTPRecipients)
+	if err != nil {
+		return nil, err
+	}
+	s := &SMTP{
+		addr:       net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+		host:       cfg.SMTPHost,
+		tlsMode:    cfg.SMTPTLS,
+		from:       cfg.SMTPFrom,
+		recipients: rcpts,
+		timeout:    cfg.Timeout,
+	}
+	if cfg.SMTPUsername != "" {
+		s.auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return s, nil
+}
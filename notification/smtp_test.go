@@ -0,0 +1,170 @@
+package notification
+
+import (
+	"bufio"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+type smtpSession struct {
+	from  string
+	rcpts []string
+	data  []byte
+}
+
+// fakeSMTP accepts a single session, answering QUIT with quitCode, and
+// sends what it got on the returned channel.
+func fakeSMTP(t *testing.T, quitCode int) (string, <-chan *smtpSession) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := make(chan *smtpSession, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tc := textproto.NewConn(conn)
+		s := &smtpSession{}
+		defer func() { sessions <- s }()
+		tc.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tc.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch {
+			case cmd == "EHLO" || cmd == "HELO":
+				tc.PrintfLine("250 localhost")
+			case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+				s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				tc.PrintfLine("250 ok")
+			case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+				s.rcpts = append(s.rcpts, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				tc.PrintfLine("250 ok")
+			case cmd == "DATA":
+				tc.PrintfLine("354 go ahead")
+				if s.data, err = ioutil.ReadAll(tc.DotReader()); err != nil {
+					return
+				}
+				tc.PrintfLine("250 queued")
+			case cmd == "QUIT":
+				tc.PrintfLine("%d bye", quitCode)
+				return
+			default:
+				tc.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), sessions
+}
+
+func TestSMTPPost(t *testing.T) {
+	tests := []struct {
+		name     string
+		channel  string
+		quitCode int
+		rcpts    []string
+	}{
+		{"channel recipients", "#alerts", 221, []string{"a@example.com", "b@example.com"}},
+		{"default recipients", "#other", 221, []string{"ops@example.com"}},
+		{"quit failure after data", "#alerts", 554, []string{"a@example.com", "b@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, sessions := fakeSMTP(t, tt.quitCode)
+			host, _, _ := net.SplitHostPort(addr)
+			s := &SMTP{
+				addr:    addr,
+				host:    host,
+				tlsMode: "none",
+				from:    "sindico@example.com",
+				recipients: map[string][]string{
+					"#alerts": {"a@example.com", "b@example.com"},
+					"*":       {"ops@example.com"},
+				},
+				timeout: 5 * time.Second,
+			}
+			m := &Message{
+				Meta:  Meta{Cluster: "prod", Severity: SeverityWarning},
+				Title: "LoadBalancer service without firewall rules",
+			}
+			m.AddField("Namespace", "ns")
+			if err := s.Post(m, tt.channel); err != nil {
+				t.Fatalf("got %v, want no error", err)
+			}
+			got := <-sessions
+			if got.from != "sindico@example.com" {
+				t.Errorf("got MAIL FROM %q", got.from)
+			}
+			if strings.Join(got.rcpts, ",") != strings.Join(tt.rcpts, ",") {
+				t.Errorf("got RCPT TO %v, want %v", got.rcpts, tt.rcpts)
+			}
+			checkSMTPMessage(t, got.data, tt.rcpts)
+		})
+	}
+}
+
+func checkSMTPMessage(t *testing.T, data []byte, rcpts []string) {
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]string{
+		"From":    "sindico@example.com",
+		"To":      strings.Join(rcpts, ", "),
+		"Subject": "[prod] LoadBalancer service without firewall rules",
+	}
+	for name, want := range headers {
+		got := msg.Header.Get(name)
+		if name == "Subject" {
+			got = subject
+		}
+		if got != want {
+			t.Errorf("got %s %q, want %q", name, got, want)
+		}
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("got content type %q (%v), want multipart/alternative", mediaType, err)
+	}
+	parts := map[string]string{
+		"text/plain; charset=utf-8": "*Namespace*: ns",
+		"text/html; charset=utf-8":  `<th align="left">Namespace</th><td>ns</td>`,
+	}
+	mr := multipart.NewReader(bufio.NewReader(msg.Body), params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		body, _ := ioutil.ReadAll(p)
+		want, found := parts[p.Header.Get("Content-Type")]
+		if !found {
+			t.Errorf("unexpected part %q", p.Header.Get("Content-Type"))
+			continue
+		}
+		if !strings.Contains(string(body), want) {
+			t.Errorf("got %s part %q, want it to contain %q", p.Header.Get("Content-Type"), body, want)
+		}
+		delete(parts, p.Header.Get("Content-Type"))
+	}
+	for contentType := range parts {
+		t.Errorf("missing %s part", contentType)
+	}
+}
@@ -0,0 +1,147 @@
+package notification
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	slackBoldRe  = regexp.MustCompile(`\*([^*\n]+)\*`)
+	slackEmojiRe = regexp.MustCompile(`:[a-z0-9_+-]+:\s?`)
+)
+
+// Teams posts MessageCards to Microsoft Teams incoming webhooks. Teams
+// webhooks belong to a single channel, so channels are mapped to webhook
+// urls, falling back to the default one.
+type Teams struct {
+	client   *http.Client
+	url      string
+	channels map[string]string
+}
+
+type messageCard struct {
+	Type            string        `json:"@type"`
+	Context         string        `json:"@context"`
+	Summary         string        `json:"summary"`
+	ThemeColor      string        `json:"themeColor,omitempty"`
+	Title           string        `json:"title,omitempty"`
+	Text            string        `json:"text,omitempty"`
+	Sections        []cardSection `json:"sections,omitempty"`
+	PotentialAction []cardAction  `json:"potentialAction,omitempty"`
+	Markdown        bool          `json:"markdown"`
+}
+
+type cardSection struct {
+	Facts    []cardFact `json:"facts"`
+	Markdown bool       `json:"markdown"`
+}
+
+type cardFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cardAction struct {
+	Type    string       `json:"@type"`
+	Name    string       `json:"name"`
+	Targets []cardTarget `json:"targets"`
+}
+
+type cardTarget struct {
+	OS  string `json:"os"`
+	URI string `json:"uri"`
+}
+
+var teamsColors = map[string]string{
+	"good":    "2EB886",
+	"danger":  "D40E0D",
+	"warning": "DAA038",
+}
+
+func (t *Teams) Post(m *Message, channel string) error {
+	url, found := t.channels[channel]
+	if !found {
+		url = t.url
+	}
+	if url == "" {
+		return fmt.Errorf("no teams webhook for channel %s", channel)
+	}
+	body, err := json.Marshal(teamsCard(m))
+	if err != nil {
+		return err
+	}
+	return postJSON(t.client, url, body, nil)
+}
+
+func teamsCard(m *Message) *messageCard {
+	card := &messageCard{
+		Type:     "MessageCard",
+		Context:  "http://schema.org/extensions",
+		Markdown: true,
+	}
+	if !m.structured() {
+		card.Text = slackToMarkdown(m.String())
+		card.Summary = summary(card.Text)
+		return card
+	}
+	card.Summary = m.subject()
+	card.Title = m.subject()
+	card.ThemeColor = strings.TrimPrefix(m.color(), "#")
+	if c, found := teamsColors[m.color()]; found {
+		card.ThemeColor = c
+	}
+	card.Text = slackToMarkdown(m.Text)
+	if len(m.Fields) > 0 {
+		s := cardSection{Markdown: true}
+		for _, f := range m.Fields {
+			s.Facts = append(s.Facts, cardFact{Name: f.Name, Value: slackToMarkdown(f.Value)})
+		}
+		card.Sections = append(card.Sections, s)
+	}
+	for _, l := range m.Links {
+		card.PotentialAction = append(card.PotentialAction, cardAction{
+			Type:    "OpenUri",
+			Name:    l.Title,
+			Targets: []cardTarget{{OS: "default", URI: l.URL}},
+		})
+	}
+	return card
+}
+
+// slackToMarkdown converts slack formatting to the markdown used by Teams.
+// Emoji names are dropped and lines are kept apart as paragraphs.
+func slackToMarkdown(msg string) string {
+	msg = slackEmojiRe.ReplaceAllString(msg, "")
+	msg = slackBoldRe.ReplaceAllString(msg, "**$1**")
+	lines := []string{}
+	for _, line := range strings.Split(msg, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n\n")
+}
+
+func summary(text string) string {
+	line := strings.SplitN(text, "\n", 2)[0]
+	return strings.Replace(line, "**", "", -1)
+}
+
+func newTeams(cfg *Config) (*Teams, error) {
+	channels, err := mapping(cfg.TeamsChannels)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TeamsWebhookURL == "" && len(channels) == 0 {
+		return nil, errors.New("teams backend needs a webhook url")
+	}
+	return &Teams{
+		client:   &http.Client{Timeout: cfg.Timeout},
+		url:      cfg.TeamsWebhookURL,
+		channels: channels,
+	}, nil
+}
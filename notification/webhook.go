@@ -0,0 +1,96 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Webhook posts messages to any http receiver with a json body rendered
+// from a template, e.g. {"channel": {{json .Channel}}, "text": {{json .Message}}}.
+// Besides the rendered .Message, templates get the fields of the structured
+// message, e.g. .Title, .Severity or .Fields.
+type Webhook struct {
+	client  *http.Client
+	url     string
+	tmpl    *template.Template
+	headers map[string]string
+}
+
+type webhookData struct {
+	Message    string
+	Channel    string
+	Time       time.Time
+	Title      string
+	Text       string
+	Severity   string
+	Cluster    string
+	Controller string
+	Namespace  string
+	Team       string
+	Resolved   bool
+	Fields     []Field
+	Links      []Link
+}
+
+func (w *Webhook) Post(m *Message, channel string) error {
+	data := &webhookData{
+		Message:    m.String(),
+		Channel:    channel,
+		Time:       time.Now(),
+		Title:      m.Title,
+		Text:       m.Text,
+		Severity:   m.Severity,
+		Cluster:    m.Cluster,
+		Controller: m.Controller,
+		Namespace:  m.Namespace,
+		Team:       m.Team,
+		Resolved:   m.Resolved,
+		Fields:     m.Fields,
+		Links:      m.Links,
+	}
+	var body bytes.Buffer
+	if err := w.tmpl.Execute(&body, data); err != nil {
+		return fmt.Errorf("failed to render webhook body: %v", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return fmt.Errorf("webhook body is not valid json: %s", body.String())
+	}
+	return postJSON(w.client, w.url, body.Bytes(), w.headers)
+}
+
+func newWebhook(cfg *Config) (*Webhook, error) {
+	if cfg.WebhookURL == "" {
+		return nil, errors.New("webhook backend needs a url")
+	}
+	tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(cfg.WebhookTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %v", err)
+	}
+	headers := make(map[string]string, len(cfg.WebhookHeaders))
+	for _, h := range cfg.WebhookHeaders {
+		i := strings.Index(h, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid webhook header %q, use Name: value", h)
+		}
+		headers[strings.TrimSpace(h[:i])] = strings.TrimSpace(h[i+1:])
+	}
+	return &Webhook{
+		client:  &http.Client{Timeout: cfg.Timeout},
+		url:     cfg.WebhookURL,
+		tmpl:    tmpl,
+		headers: headers,
+	}, nil
+}
@@ -0,0 +1,22 @@
+package notification
+
+import (
+	"fmt"
+	"io"
+)
+
+// Writer prints messages to w instead of sending them, it's used by the
+// one-shot commands.
+type Writer struct {
+	w io.Writer
+}
+
+func (p *Writer) Post(m *Message, channel string) error {
+	_, err := fmt.Fprintln(p.w, m.String())
+	return err
+}
+
+func NewWriter(w io.Writer) *Client {
+	r := &router{def: "writer", backends: map[string]Poster{"writer": &Writer{w: w}}}
+	return &Client{router: r, failures: newFailuresMetric()}
+}
@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/luizalabs/sindico/status"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron"
+)
+
+const tzPrefix = "CRON_TZ="
+
+var (
+	parser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+	mu       sync.Mutex
+	location = time.Local
+)
+
+// SetLocation sets the timezone of cron specs without a CRON_TZ prefix.
+func SetLocation(loc *time.Location) {
+	mu.Lock()
+	location = loc
+	mu.Unlock()
+}
+
+// Every returns the spec of a schedule activated every d.
+func Every(d time.Duration) string {
+	return fmt.Sprintf("@every %s", d)
+}
+
+// Parse parses a standard 5 field cron spec or a descriptor like @daily or
+// @every 5m, optionally prefixed by CRON_TZ=<zone> to use a zone other than
+// the one given to SetLocation.
+func Parse(spec string) (cron.Schedule, *time.Location, error) {
+	mu.Lock()
+	loc := location
+	mu.Unlock()
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, tzPrefix) {
+		i := strings.Index(spec, " ")
+		if i < 0 {
+			return nil, nil, fmt.Errorf("missing schedule after %s", spec)
+		}
+		var err error
+		loc, err = time.LoadLocation(spec[len(tzPrefix):i])
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid timezone")
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+	sched, err := parser.Parse(spec)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid schedule %q", spec)
+	}
+	return sched, loc, nil
+}
+
+// Run calls fn at every activation of the schedule returned by spec until
+// stopCh is closed. spec is called again after every run, so a new schedule
+// takes effect from the following activation. fn is called synchronously, so
+// runs never overlap: activations missed while fn was running are skipped.
+func Run(st *status.Component, spec func() string, fn func(), stopCh <-chan struct{}) error {
+	logger := log.New("component", "scheduler", "job", st.Name())
+	if c := st.Cluster(); c != "" {
+		logger = logger.New("cluster", c)
+	}
+	current := spec()
+	sched, loc, err := Parse(current)
+	if err != nil {
+		return err
+	}
+	for {
+		next := sched.Next(time.Now().In(loc))
+		st.SetNextRun(next)
+		logger.Debug("next run", "at", next)
+		select {
+		case <-stopCh:
+			return nil
+		case <-time.After(time.Until(next)):
+		}
+		fn()
+		if missed := sched.Next(next); missed.Before(time.Now()) {
+			logger.Warn("run took longer than the schedule, skipping missed activations", "missed", missed)
+		}
+		if s := spec(); s != current {
+			if newSched, newLoc, err := Parse(s); err != nil {
+				logger.Error("invalid schedule, keeping the previous one", "schedule", s, "err", err)
+			} else {
+				logger.Info("schedule changed", "schedule", s)
+				current, sched, loc = s, newSched, newLoc
+			}
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package status
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	mu         sync.Mutex
+	components = make(map[string]*Component)
+)
+
+type Component struct {
+	cluster string
+	name    string
+
+	mu        sync.Mutex
+	alive     bool
+	exited    bool
+	running   bool
+	startedAt time.Time
+	runs      int
+	lastRun   time.Time
+	lastDur   time.Duration
+	lastErr   error
+	lastErrAt time.Time
+	nextRun   time.Time
+	restarts  int
+	restartAt time.Time
+	failures  int
+	failingAt time.Time
+}
+
+type Report struct {
+	Cluster      string     `json:"cluster,omitempty"`
+	Name         string     `json:"name"`
+	Alive        bool       `json:"alive"`
+	Exited       bool       `json:"exited"`
+	Running      bool       `json:"running"`
+	StartedAt    *time.Time `json:"startedAt,omitempty"`
+	Runs         int        `json:"runs"`
+	LastRun      *time.Time `json:"lastRun,omitempty"`
+	LastDuration string     `json:"lastDuration,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	LastErrorAt  *time.Time `json:"lastErrorAt,omitempty"`
+	NextRun      *time.Time `json:"nextRun,omitempty"`
+	Restarts     int        `json:"restarts"`
+	NextRestart  *time.Time `json:"nextRestart,omitempty"`
+	Failures     int        `json:"consecutiveFailures"`
+	FailingSince *time.Time `json:"failingSince,omitempty"`
+}
+
+// Healthy is false when the component stopped unexpectedly and isn't going
+// to be restarted, or is crash looping: it failed restarts times in a row or
+// has been failing for longer than window, without running for window since.
+func (r *Report) Healthy(restarts int, window time.Duration) bool {
+	if r.Exited && r.NextRestart == nil {
+		return false
+	}
+	if r.Failures == 0 {
+		return true
+	}
+	if r.Alive && r.StartedAt != nil && time.Since(*r.StartedAt) > window {
+		return true
+	}
+	return r.Failures < restarts && time.Since(*r.FailingSince) <= window
+}
+
+// For returns the component registered with name on cluster, creating it
+// if needed. cluster is empty when sindico watches a single unnamed cluster.
+func For(cluster, name string) *Component {
+	mu.Lock()
+	defer mu.Unlock()
+	key := cluster + "\x00" + name
+	c, found := components[key]
+	if !found {
+		c = &Component{cluster: cluster, name: name}
+		components[key] = c
+	}
+	return c
+}
+
+func Reports() []Report {
+	mu.Lock()
+	cs := make([]*Component, 0, len(components))
+	for _, c := range components {
+		cs = append(cs, c)
+	}
+	mu.Unlock()
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].cluster != cs[j].cluster {
+			return cs[i].cluster < cs[j].cluster
+		}
+		return cs[i].name < cs[j].name
+	})
+	reports := make([]Report, len(cs))
+	for i, c := range cs {
+		reports[i] = c.Report()
+	}
+	return reports
+}
+
+func (c *Component) Started() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.alive = true
+	c.exited = false
+	c.startedAt = time.Now()
+	c.restartAt = time.Time{}
+}
+
+// Stopped marks the component goroutine as finished. An unexpected stop
+// (i.e. not requested by the manager) makes the component unhealthy.
+func (c *Component) Stopped(expected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.alive = false
+	c.running = false
+	c.exited = !expected
+}
+
+// Restarting records that the component stopped unexpectedly and will be
+// started again at t.
+func (c *Component) Restarting(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.restarts++
+	c.restartAt = t
+	if c.failures == 0 {
+		c.failingAt = time.Now()
+	}
+	c.failures++
+}
+
+// Recovered resets the consecutive failures, e.g. once the component ran
+// for a while before failing again.
+func (c *Component) Recovered() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = 0
+	c.failingAt = time.Time{}
+}
+
+// Run calls fn recording its duration and error.
+func (c *Component) Run(fn func() error) error {
+	c.mu.Lock()
+	c.running = true
+	c.mu.Unlock()
+
+	start := time.Now()
+	err := fn()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = false
+	c.runs++
+	c.lastRun = start
+	c.lastDur = time.Since(start)
+	if err != nil {
+		c.lastErr = err
+		c.lastErrAt = time.Now()
+	}
+	return err
+}
+
+func (c *Component) Fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastErr = err
+	c.lastErrAt = time.Now()
+}
+
+func (c *Component) SetNextRun(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextRun = t
+}
+
+func (c *Component) Name() string {
+	return c.name
+}
+
+func (c *Component) Cluster() string {
+	return c.cluster
+}
+
+// ID is the name prefixed by the cluster, if any, e.g. prod/kubewatch.
+func (c *Component) ID() string {
+	if c.cluster == "" {
+		return c.name
+	}
+	return c.cluster + "/" + c.name
+}
+
+func (c *Component) Report() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := Report{
+		Cluster:      c.cluster,
+		Name:         c.name,
+		Alive:        c.alive,
+		Exited:       c.exited,
+		Running:      c.running,
+		Runs:         c.runs,
+		StartedAt:    timePtr(c.startedAt),
+		LastRun:      timePtr(c.lastRun),
+		NextRun:      timePtr(c.nextRun),
+		Restarts:     c.restarts,
+		NextRestart:  timePtr(c.restartAt),
+		Failures:     c.failures,
+		FailingSince: timePtr(c.failingAt),
+	}
+	if c.runs > 0 {
+		r.LastDuration = c.lastDur.String()
+	}
+	if c.lastErr != nil {
+		r.LastError = c.lastErr.Error()
+		r.LastErrorAt = timePtr(c.lastErrAt)
+	}
+	return r
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,33 @@
+package status
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReportHealthy(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+	tests := []struct {
+		name   string
+		report Report
+		want   bool
+	}{
+		{"running", Report{Alive: true, StartedAt: ago(time.Hour)}, true},
+		{"exited without restart", Report{Exited: true}, false},
+		{"restarting", Report{Exited: true, NextRestart: ago(-time.Minute), Failures: 1, FailingSince: ago(time.Minute)}, true},
+		{"crash looping", Report{Exited: true, NextRestart: ago(-time.Minute), Failures: 5, FailingSince: ago(5 * time.Minute)}, false},
+		{"failing for too long", Report{Alive: true, StartedAt: ago(time.Minute), Failures: 2, FailingSince: ago(20 * time.Minute)}, false},
+		{"running again for a while", Report{Alive: true, StartedAt: ago(20 * time.Minute), Failures: 5, FailingSince: ago(time.Hour)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.report.Healthy(5, 15*time.Minute); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const dateFormat = "2006-01-02"
+
+type ReadWriter interface {
+	Uploader
+	Reader
+	Lister
+}
+
+// Daily keeps records as JSON lines in a directory per day under dir, one
+// file per Append named by the time and host of the write, e.g.
+// audit/2018-08-01/1533117600000000000-sindico-6c8f9.jsonl. The storage has
+// no append operation and the manager and the command line may write at the
+// same time, so files are never rewritten.
+type Daily struct {
+	st   ReadWriter
+	dir  string
+	host string
+	mu   sync.Mutex
+	last int64
+}
+
+func NewDaily(st ReadWriter, dir string) *Daily {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return &Daily{st: st, dir: dir, host: strings.Replace(host, "/", "-", -1)}
+}
+
+func (d *Daily) day(t time.Time) string {
+	return fmt.Sprintf("%s/%s", d.dir, t.UTC().Format(dateFormat))
+}
+
+// name returns a new file of day, the times of the same host never repeat.
+func (d *Daily) name(day time.Time) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ts := time.Now().UnixNano()
+	if ts <= d.last {
+		ts = d.last + 1
+	}
+	d.last = ts
+	return fmt.Sprintf("%s/%019d-%s.jsonl", d.day(day), ts, d.host)
+}
+
+// Append writes records to a new file of day.
+func (d *Daily) Append(day time.Time, records ...interface{}) error {
+	if len(records) == 0 {
+		return nil
+	}
+	path := d.name(day)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return errors.Wrapf(err, "failed to encode record of %s", path)
+		}
+	}
+	return d.st.UploadFile(path, bytes.NewReader(buf.Bytes()))
+}
+
+// files returns the files of day in the order they were written, after the
+// single file of day kept by older versions, e.g. audit/2018-08-01.jsonl.
+func (d *Daily) files(day time.Time) ([]string, error) {
+	dir := d.day(day)
+	paths, err := d.st.List(dir + "/")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return append([]string{dir + ".jsonl"}, paths...), nil
+}
+
+// Scan calls fn with every line of the files of the last days, oldest
+// first, stopping at the first error.
+func (d *Daily) Scan(days int, fn func(line []byte) error) error {
+	now := time.Now()
+	for i := days - 1; i >= 0; i-- {
+		paths, err := d.files(now.AddDate(0, 0, -i))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if err := d.scan(path, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Daily) scan(path string, fn func(line []byte) error) error {
+	data, err := d.st.ReadFile(path)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return errors.Wrapf(err, "invalid record in %s", path)
+		}
+	}
+	return errors.Wrapf(sc.Err(), "failed to read %s", path)
+}
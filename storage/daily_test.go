@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (s *memStorage) UploadFile(path string, r io.ReadSeeker) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path] = data
+	return nil
+}
+
+func (s *memStorage) ReadFile(path string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[path]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
+
+func (s *memStorage) List(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths []string
+	for path := range s.files {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+func TestDaily(t *testing.T) {
+	now := time.Now().UTC()
+	yesterday := now.AddDate(0, 0, -1)
+	st := &memStorage{files: map[string][]byte{
+		"audit/" + yesterday.Format(dateFormat) + ".jsonl": []byte("\"old\"\n"),
+	}}
+	// two processes sharing the storage, e.g. the manager and the cli
+	manager, cli := NewDaily(st, "audit"), NewDaily(st, "audit")
+	manager.host, cli.host = "manager", "cli"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			manager.Append(now, "manager")
+		}()
+		go func() {
+			defer wg.Done()
+			cli.Append(now, "cli")
+		}()
+	}
+	wg.Wait()
+	if err := manager.Append(yesterday, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err := cli.Scan(2, func(line []byte) error {
+		got = append(got, string(line))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`"old"`, `"a"`, `"b"`}; len(got) != 23 || !reflect.DeepEqual(got[:3], want) {
+		t.Fatalf("got %q, want %q and 20 records of today", got, want)
+	}
+	count := make(map[string]int)
+	for _, line := range got[3:] {
+		count[line]++
+	}
+	if want := map[string]int{`"manager"`: 10, `"cli"`: 10}; !reflect.DeepEqual(count, want) {
+		t.Errorf("got today %v, want %v", count, want)
+	}
+}
@@ -2,8 +2,10 @@ package storage
 
 import (
 	"io"
+	"io/ioutil"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -12,6 +14,8 @@ import (
 
 type S3Client interface {
 	PutObject(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	GetObject(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	ListObjectsPages(*s3.ListObjectsInput, func(*s3.ListObjectsOutput, bool) bool) error
 }
 
 type S3 struct {
@@ -25,6 +29,31 @@ func (s *S3) UploadFile(path string, r io.ReadSeeker) error {
 	return errors.Wrapf(err, "failed to upload file %s", path)
 }
 
+func (s *S3) ReadFile(path string) ([]byte, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{Bucket: &s.bucket, Key: &path})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "failed to read file %s", path)
+	}
+	defer out.Body.Close()
+	data, err := ioutil.ReadAll(out.Body)
+	return data, errors.Wrapf(err, "failed to read file %s", path)
+}
+
+func (s *S3) List(prefix string) ([]string, error) {
+	var paths []string
+	in := &s3.ListObjectsInput{Bucket: &s.bucket, Prefix: &prefix}
+	err := s.client.ListObjectsPages(in, func(out *s3.ListObjectsOutput, last bool) bool {
+		for _, o := range out.Contents {
+			paths = append(paths, aws.StringValue(o.Key))
+		}
+		return true
+	})
+	return paths, errors.Wrapf(err, "failed to list files of %s", prefix)
+}
+
 func newS3(cfg *Config) *S3 {
 	st := &S3{bucket: cfg.Bucket}
 	awsCfg := &aws.Config{
@@ -1,6 +1,11 @@
 package storage
 
-import "io"
+import (
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("file not found")
 
 type Config struct {
 	Key    string `split_words:"true"`
@@ -13,10 +18,23 @@ type Uploader interface {
 	UploadFile(path string, r io.ReadSeeker) error
 }
 
+// Reader returns ErrNotFound for missing files.
+type Reader interface {
+	ReadFile(path string) ([]byte, error)
+}
+
+// Lister returns the paths of the files starting with prefix.
+type Lister interface {
+	List(prefix string) ([]string, error)
+}
+
 type Client struct {
 	Uploader
+	Reader
+	Lister
 }
 
 func New(cfg *Config) *Client {
-	return &Client{newS3(cfg)}
+	s3 := newS3(cfg)
+	return &Client{Uploader: s3, Reader: s3, Lister: s3}
 }